@@ -47,7 +47,8 @@ func ReadConfig(configFile string) (*Config, error) {
 		return nil, err
 	}
 	orgConfig := org.New()
-	document := orgConfig.Parse(f, configFile)
+	orgConfig.FS = os.DirFS(workingDir)
+	document := orgConfig.Parse(f, filepath.Base(configFile))
 	if document.Error != nil {
 		return nil, document.Error
 	}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/niklasfasching/go-org/org"
@@ -24,7 +25,11 @@ func NewPage(c *Config, path string, info os.FileInfo) (*Page, error) {
 	if err != nil {
 		return nil, err
 	}
-	d := c.OrgConfig.Parse(f, path)
+	relPath, err := filepath.Rel(filepath.Dir(c.ConfigFile), path)
+	if err != nil {
+		return nil, err
+	}
+	d := c.OrgConfig.Parse(f, relPath)
 	content, err := d.Write(getWriter())
 	if err != nil {
 		return nil, err
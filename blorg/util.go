@@ -5,10 +5,7 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/alecthomas/chroma"
-	"github.com/alecthomas/chroma/formatters/html"
-	"github.com/alecthomas/chroma/lexers"
-	"github.com/alecthomas/chroma/styles"
+	"github.com/niklasfasching/go-org/highlight"
 	"github.com/niklasfasching/go-org/org"
 )
 
@@ -50,22 +47,5 @@ func slugify(s string) string {
 }
 
 func getWriter() org.Writer {
-	w := org.NewHTMLWriter()
-	w.HighlightCodeBlock = highlightCodeBlock
-	return w
-}
-
-func highlightCodeBlock(source, lang string, inline bool) string {
-	var w strings.Builder
-	l := lexers.Get(lang)
-	if l == nil {
-		l = lexers.Fallback
-	}
-	l = chroma.Coalesce(l)
-	it, _ := l.Tokenise(nil, source)
-	_ = html.New().Format(&w, styles.Get("github"), it)
-	if inline {
-		return `<div class="highlight-inline">` + "\n" + w.String() + "\n" + `</div>`
-	}
-	return `<div class="highlight">` + "\n" + w.String() + "\n" + `</div>`
+	return highlight.New("github").Writer()
 }
@@ -0,0 +1,97 @@
+// Package highlight provides syntax highlighting of src blocks for org.HTMLWriter using chroma.
+package highlight
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/niklasfasching/go-org/org"
+)
+
+// Aliases maps the names of org babel languages to the names of the chroma lexers used to highlight them.
+var Aliases = map[string]string{
+	"emacs-lisp":     "elisp",
+	"sh":             "bash",
+	"shell":          "bash",
+	"shell-script":   "bash",
+	"ipython":        "python",
+	"jupyter-python": "python",
+	"sqlite":         "sql",
+	"conf":           "ini",
+}
+
+// Highlighter highlights src blocks - see Highlighter.HighlightCodeBlock.
+type Highlighter struct {
+	Style string // Style is the name of the chroma style - e.g. friendly or github.
+	// Classes writes css classes instead of inline styles. The matching stylesheet is generated by CSS.
+	Classes bool
+	// LineNumbers numbers the lines of all (non-inline) src blocks.
+	// Blocks with the -n switch (or coderefs) are numbered by org.HTMLWriter instead (see org.HasGutter).
+	LineNumbers bool
+}
+
+// New returns a Highlighter for the chroma style with inline styles.
+func New(style string) *Highlighter { return &Highlighter{Style: style} }
+
+// Writer returns a html writer that highlights src blocks using h.
+func (h *Highlighter) Writer() *org.HTMLWriter {
+	w := org.NewHTMLWriter()
+	w.HighlightCodeBlockWithParams = h.HighlightCodeBlock
+	return w
+}
+
+// HighlightCodeBlock highlights source and marks the lines of the :hl_lines header argument in params.
+// It can be used as org.HTMLWriter.HighlightCodeBlockWithParams.
+func (h *Highlighter) HighlightCodeBlock(source, lang string, inline bool, params map[string]string) string {
+	var w strings.Builder
+	it, _ := chroma.Coalesce(Lexer(lang)).Tokenise(nil, source)
+	_ = h.formatter(inline, params).Format(&w, h.style(), it)
+	if inline {
+		return `<div class="highlight-inline">` + "\n" + w.String() + "\n" + `</div>`
+	}
+	return `<div class="highlight">` + "\n" + w.String() + "\n" + `</div>`
+}
+
+// CSS returns the stylesheet for the classes written by h (see Highlighter.Classes).
+// Unknown styles are an error rather than falling back to the default style.
+func (h *Highlighter) CSS() (string, error) {
+	if _, ok := styles.Registry[h.Style]; !ok {
+		return "", fmt.Errorf("unknown chroma style %q", h.Style)
+	}
+	var w strings.Builder
+	if err := html.New(html.WithClasses(true)).WriteCSS(&w, h.style()); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// Lexer returns the chroma lexer for the org babel language lang - or the fallback lexer for unknown languages.
+func Lexer(lang string) chroma.Lexer {
+	lang = strings.ToLower(lang)
+	if alias, ok := Aliases[lang]; ok {
+		lang = alias
+	}
+	if l := lexers.Get(lang); l != nil {
+		return l
+	}
+	return lexers.Fallback
+}
+
+func (h *Highlighter) formatter(inline bool, params map[string]string) *html.Formatter {
+	options := []html.Option{html.HighlightLines(org.HighlightedLines(params))}
+	if h.Classes {
+		options = append(options, html.WithClasses(true))
+	}
+	if h.LineNumbers && !inline && !org.HasGutter(params) {
+		options = append(options, html.WithLineNumbers(true), html.LineNumbersInTable(true))
+	}
+	return html.New(options...)
+}
+
+func (h *Highlighter) style() *chroma.Style {
+	return styles.Get(h.Style)
+}
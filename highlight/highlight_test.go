@@ -0,0 +1,56 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/niklasfasching/go-org/org"
+)
+
+func TestHighlightCodeBlock(t *testing.T) {
+	h := &Highlighter{Style: "github", Classes: true, LineNumbers: true}
+	actual := h.HighlightCodeBlock("(defun f ()\n  1)\n", "emacs-lisp", false, map[string]string{":hl_lines": "2"})
+	for _, expected := range []string{
+		`<div class="highlight">`,
+		`<span class="nb">defun</span>`,
+		`<span class="hl">`,
+		`<span class="lnt">2`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+	if strings.Contains(actual, "style=") {
+		t.Errorf("expected output without inline styles:\n%s", actual)
+	}
+	css, err := h.CSS()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !strings.Contains(css, ".chroma .nb {") {
+		t.Errorf("expected css for class nb:\n%s", css)
+	}
+	if _, err := New("typo").CSS(); err == nil {
+		t.Errorf("expected error for unknown style")
+	}
+}
+
+func TestHighlightWithGutter(t *testing.T) {
+	writer := (&Highlighter{Style: "github", Classes: true, LineNumbers: true}).Writer()
+	input := "#+BEGIN_SRC go -n\nfunc main() {}\n#+END_SRC\n"
+	actual, err := org.New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !strings.Contains(actual, `<table class="linenos">`) || strings.Contains(actual, `class="lnt"`) {
+		t.Errorf("expected only the line numbers of org:\n%s", actual)
+	}
+}
+
+func TestLexerAliases(t *testing.T) {
+	for lang, expected := range map[string]string{"emacs-lisp": "EmacsLisp", "sh": "Bash", "ipython": "Python", "unknown": "fallback"} {
+		if actual := Lexer(lang).Config().Name; actual != expected {
+			t.Errorf("%s: expected lexer %s, got %s", lang, expected, actual)
+		}
+	}
+}
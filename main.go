@@ -2,30 +2,46 @@ package main
 
 import (
 	"bytes"
+	"context"
+	_ "embed"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/alecthomas/chroma"
-	"github.com/alecthomas/chroma/formatters/html"
-	"github.com/alecthomas/chroma/lexers"
-	"github.com/alecthomas/chroma/styles"
 	"github.com/niklasfasching/go-org/blorg"
+	"github.com/niklasfasching/go-org/highlight"
 	"github.com/niklasfasching/go-org/org"
 )
 
 var usage = `Usage: go-org COMMAND [ARGS]...
 Commands:
-- render FILE FORMAT
-  FORMAT: org, html, html-chroma
+- render FILE FORMAT [--standalone] [--style=STYLE] [--classes]
+  file access (e.g. #+INCLUDE) is restricted to the working directory
+  FORMAT: org, html, html-chroma, txt, man, slides
+  --standalone renders html as a complete document with inlined stylesheet and images
+  --style sets the chroma STYLE of html-chroma (defaults to friendly)
+  --classes highlights html-chroma with css classes instead of inline styles (see highlight-css)
+  slides renders a reveal.js presentation that expects reveal.js in ./reveal.js (see #+REVEAL_ROOT)
+- highlight-css STYLE
+  prints the stylesheet for src blocks highlighted with css classes and the chroma STYLE (e.g. friendly)
+- tangle FILE [--dry-run]
+  writes the src blocks of FILE to their :tangle targets (--dry-run only lists the targets)
+- exec FILE [--enable] [--timeout=DURATION]
+  runs the src blocks of FILE and updates their results in place
+  nothing is executed unless --enable is passed
 - blorg
   - blorg init
   - blorg build
   - blorg serve
 `
 
+//go:embed etc/style.css
+var stylesheet string
+
 func main() {
 	log.SetFlags(0)
 	if len(os.Args) < 2 {
@@ -34,6 +50,12 @@ func main() {
 	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
 	case "render":
 		render(args)
+	case "highlight-css":
+		highlightCSS(args)
+	case "tangle":
+		tangle(args)
+	case "exec":
+		execute(args)
 	case "blorg":
 		runBlorg(args)
 	default:
@@ -81,12 +103,31 @@ func render(args []string) {
 	if len(args) < 2 {
 		log.Fatal(usage)
 	}
-	path, format := args[0], args[1]
+	path, format, standalone, highlighter := args[0], strings.ToLower(args[1]), false, highlight.New("friendly")
+	for _, arg := range args[2:] {
+		switch {
+		case arg == "--standalone":
+			standalone = true
+		case strings.HasPrefix(arg, "--style=") && format == "html-chroma":
+			highlighter.Style = strings.TrimPrefix(arg, "--style=")
+		case arg == "--classes" && format == "html-chroma":
+			highlighter.Classes = true
+		default:
+			log.Fatal(usage)
+		}
+	}
+	if _, err := highlighter.CSS(); err != nil {
+		log.Fatal(err)
+	}
 	bs, err := ioutil.ReadFile(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	d := org.New().Parse(bytes.NewReader(bs), path)
+	config, path, err := newConfiguration(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := config.Parse(bytes.NewReader(bs), path)
 	write := func(w org.Writer) {
 		out, err := d.Write(w)
 		if err != nil {
@@ -94,31 +135,149 @@ func render(args []string) {
 		}
 		fmt.Fprint(os.Stdout, out)
 	}
-	switch strings.ToLower(format) {
+	switch format {
 	case "org":
 		write(org.NewOrgWriter())
-	case "html":
-		write(org.NewHTMLWriter())
-	case "html-chroma":
+	case "txt":
+		write(org.NewTextWriter())
+	case "man":
+		write(org.NewManWriter())
+	case "slides":
+		write(org.NewSlidesWriter())
+	case "html", "html-chroma":
 		writer := org.NewHTMLWriter()
-		writer.HighlightCodeBlock = highlightCodeBlock
+		if format == "html-chroma" {
+			writer = highlighter.Writer()
+		}
+		if standalone {
+			writer.Standalone, writer.Stylesheet, writer.EmbedImages = true, stylesheet, true
+		}
 		write(writer)
 	default:
 		log.Fatal(usage)
 	}
 }
 
-func highlightCodeBlock(source, lang string, inline bool) string {
-	var w strings.Builder
-	l := lexers.Get(lang)
-	if l == nil {
-		l = lexers.Fallback
-	}
-	l = chroma.Coalesce(l)
-	it, _ := l.Tokenise(nil, source)
-	_ = html.New().Format(&w, styles.Get("friendly"), it)
-	if inline {
-		return `<div class="highlight-inline">` + "\n" + w.String() + "\n" + `</div>`
-	}
-	return `<div class="highlight">` + "\n" + w.String() + "\n" + `</div>`
+func highlightCSS(args []string) {
+	if len(args) != 1 {
+		log.Fatal(usage)
+	}
+	css, err := (&highlight.Highlighter{Style: args[0], Classes: true}).CSS()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprint(os.Stdout, css)
+}
+
+func tangle(args []string) {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "--dry-run") {
+		log.Fatal(usage)
+	}
+	path, dryRun := args[0], len(args) == 2
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, relPath, err := newConfiguration(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files, err := config.Parse(bytes.NewReader(bs), relPath).Tangle()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range files {
+		target := f.Path
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if dryRun {
+			fmt.Println(target)
+			continue
+		}
+		if f.Mkdirp {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(target, []byte(f.Content), f.Mode); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.Chmod(target, f.Mode); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %s", target)
+	}
+}
+
+func execute(args []string) {
+	if len(args) < 1 {
+		log.Fatal(usage)
+	}
+	path, executor := args[0], org.NewExecutor()
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--enable":
+			executor.Enabled = true
+		case strings.HasPrefix(arg, "--timeout="):
+			timeout, err := time.ParseDuration(strings.TrimPrefix(arg, "--timeout="))
+			if err != nil {
+				log.Fatal(err)
+			}
+			executor.Timeout = timeout
+		default:
+			log.Fatal(usage)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, relPath, err := newConfiguration(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := config.Parse(bytes.NewReader(bs), relPath)
+	executor.Dir = filepath.Dir(path)
+	executionErr := executor.Execute(context.Background(), d)
+	if executionErr == org.ErrExecutionDisabled {
+		log.Fatalf("%s - pass --enable to run the src blocks of %s", executionErr, path)
+	} else if _, ok := executionErr.(org.ExecutionErrors); executionErr != nil && !ok {
+		log.Fatal(executionErr)
+	}
+	// the results of the blocks that succeeded are written even if others failed
+	out, err := d.Write(org.NewOrgWriter())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(out), info.Mode()); err != nil {
+		log.Fatal(err)
+	}
+	if executionErr != nil {
+		log.Fatal(executionErr)
+	}
+}
+
+// newConfiguration returns a configuration that restricts file access (e.g. #+INCLUDE) to the working directory
+// - or the directory of path if path is outside of it - and path relative to that root.
+func newConfiguration(path string) (*org.Configuration, string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, "", err
+	}
+	relPath, err := filepath.Rel(root, absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		root, relPath = filepath.Dir(absPath), filepath.Base(absPath)
+	}
+	config := org.New()
+	config.FS = os.DirFS(root)
+	return config, relPath, nil
 }
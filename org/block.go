@@ -1,8 +1,11 @@
 package org
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 )
 
@@ -11,10 +14,41 @@ type Block struct {
 	Parameters []string
 	Children   []Node
 	Result     Node
+	HeaderArgs map[string]string // HeaderArgs contains the effective header arguments of src blocks (see Document.resolveHeaderArgs).
+	Switches   Switches          // Switches contains the switches of src and example blocks.
 }
 
+// Switches are the switches of src and example blocks - e.g. #+BEGIN_SRC go -n 10 -r -l "(ref:%s)".
+type Switches struct {
+	NumberLines         string // NumberLines is -n or +n (continue the numbering of the previous block) - empty if lines are not numbered.
+	Start               int    // Start is the argument of -n (first line number) or +n (offset to the previous block) - 0 if not set.
+	FirstLine           int    // FirstLine is the resolved number of the first line of numbered blocks.
+	RemoveLabels        bool   // RemoveLabels (-r) removes coderef labels from the code. Links to them show the line number instead.
+	KeepLabels          bool   // KeepLabels (-k) keeps coderef labels when tangling.
+	PreserveIndentation bool   // PreserveIndentation (-i) preserves the indentation of the code.
+	LabelFormat         string // LabelFormat (-l "FORMAT") is the format of coderef labels. Defaults to (ref:%s).
+	CodeRefBlock        int    // CodeRefBlock is the 1-based index of the block among the blocks with coderef labels - 0 if it has none.
+}
+
+// CodeRef is a coderef label (e.g. (ref:label)) in a src or example block. Links like [[(label)]] reference it.
+type CodeRef struct {
+	Line        int  // Line is the line number for numbered blocks and the 1-based index of the line in the block otherwise.
+	RemoveLabel bool // RemoveLabel is set if the block has the -r switch. Links to the label show the line number.
+	Block       int  // Block is the CodeRefBlock of the block containing the label.
+}
+
+// codeRefLabelRegexps caches the regexps returned by codeRefLabelRegexp by label format.
+var codeRefLabelRegexps = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: map[string]*regexp.Regexp{}}
+
+// maxCodeRefLabelRegexps limits the number of cached label formats - formats come from the (possibly untrusted) input.
+const maxCodeRefLabelRegexps = 64
+
 type Result struct {
 	Node Node
+	Hash string // Hash is the hash of the src block that produced the result (:cache yes).
 }
 
 type Example struct {
@@ -24,7 +58,8 @@ type Example struct {
 var exampleLineRegexp = regexp.MustCompile(`^(\s*):(\s(.*)|\s*$)`)
 var beginBlockRegexp = regexp.MustCompile(`(?i)^(\s*)#\+BEGIN_(\w+)(.*)`)
 var endBlockRegexp = regexp.MustCompile(`(?i)^(\s*)#\+END_(\w+)`)
-var resultRegexp = regexp.MustCompile(`(?i)^(\s*)#\+RESULTS:`)
+var resultRegexp = regexp.MustCompile(`(?i)^(\s*)#\+RESULTS(?:\[([0-9a-f]*)\])?:`)
+var blockSwitchArgumentRegexp = regexp.MustCompile(`"[^"]*"|\S+`)
 var exampleBlockEscapeRegexp = regexp.MustCompile(`(^|\n)([ \t]*),([ \t]*)(\*|,\*|#\+|,#\+)`)
 
 func lexBlock(line string) (token, bool) {
@@ -38,7 +73,7 @@ func lexBlock(line string) (token, bool) {
 
 func lexResult(line string) (token, bool) {
 	if m := resultRegexp.FindStringSubmatch(line); m != nil {
-		return token{"result", len(m[1]), "", m}, true
+		return token{"result", len(m[1]), m[2], m}, true
 	}
 	return nilToken, false
 }
@@ -52,6 +87,10 @@ func lexExample(line string) (token, bool) {
 
 func isRawTextBlock(name string) bool { return name == "SRC" || name == "EXAMPLE" || name == "EXPORT" }
 
+// isLineBlock returns true for blocks whose content is kept line by line rather than parsed into paragraphs:
+// raw text blocks and verse blocks (which may contain inline markup).
+func isLineBlock(name string) bool { return isRawTextBlock(name) || name == "VERSE" }
+
 func (d *Document) parseBlock(i int, parentStop stopFn) (int, Node) {
 	t, start := d.tokens[i], i
 	name, parameters := t.content, splitParameters(t.matches[3])
@@ -59,8 +98,22 @@ func (d *Document) parseBlock(i int, parentStop stopFn) (int, Node) {
 	stop := func(d *Document, i int) bool {
 		return i >= len(d.tokens) || (d.tokens[i].kind == "endBlock" && d.tokens[i].content == name)
 	}
-	block, i := Block{name, parameters, nil, nil}, i+1
-	if isRawTextBlock(name) {
+	block, i := Block{Name: name, Parameters: parameters}, i+1
+	if (name == "SRC" || name == "EXAMPLE") && len(parameters) != 0 && !strings.HasPrefix(parameters[0], ":") {
+		lang := ""
+		if lang, block.Switches = parseSwitches(parameters[0]); lang == "" {
+			block.Parameters = parameters[1:]
+		} else {
+			block.Parameters[0] = lang
+		}
+	}
+	if name == "VERSE" {
+		rawText := ""
+		for ; !stop(d, i); i++ {
+			rawText += trim(d.tokens[i].matches[0]) + "\n"
+		}
+		block.Children = d.parseInline(rawText)
+	} else if isRawTextBlock(name) {
 		rawText := ""
 		for ; !stop(d, i); i++ {
 			rawText += trim(d.tokens[i].matches[0]) + "\n"
@@ -69,6 +122,9 @@ func (d *Document) parseBlock(i int, parentStop stopFn) (int, Node) {
 			rawText = exampleBlockEscapeRegexp.ReplaceAllString(rawText, "$1$2$3$4")
 		}
 		block.Children = d.parseRawInline(rawText)
+		if name == "SRC" || name == "EXAMPLE" {
+			d.resolveCodeRefs(&block, rawText)
+		}
 	} else {
 		consumed, nodes := d.parseMany(i, stop)
 		block.Children = nodes
@@ -78,6 +134,10 @@ func (d *Document) parseBlock(i int, parentStop stopFn) (int, Node) {
 		return 0, nil
 	}
 	if name == "SRC" {
+		block.HeaderArgs = map[string]string{}
+		for k, v := range block.ParameterMap() {
+			block.HeaderArgs[k] = v
+		}
 		consumed, result := d.parseSrcBlockResult(i+1, parentStop)
 		block.Result = result
 		i += consumed
@@ -109,7 +169,102 @@ func (d *Document) parseResult(i int, parentStop stopFn) (int, Node) {
 		return 0, nil
 	}
 	consumed, node := d.parseOne(i+1, parentStop)
-	return consumed + 1, Result{node}
+	return consumed + 1, Result{Node: node, Hash: d.tokens[i].content}
+}
+
+// parseSwitches parses the switches of a src or example block and returns the remaining arguments (i.e. the language).
+func parseSwitches(s string) (string, Switches) {
+	fields, rest, switches := blockSwitchArgumentRegexp.FindAllString(s, -1), []string{}, Switches{}
+	for i := 0; i < len(fields); i++ {
+		switch f := fields[i]; f {
+		case "-n", "+n":
+			switches.NumberLines = f
+			if i+1 < len(fields) {
+				if n, err := strconv.Atoi(fields[i+1]); err == nil {
+					switches.Start, i = n, i+1
+				}
+			}
+		case "-r":
+			switches.RemoveLabels = true
+		case "-k":
+			switches.KeepLabels = true
+		case "-i":
+			switches.PreserveIndentation = true
+		case "-l":
+			if i+1 < len(fields) {
+				switches.LabelFormat, i = strings.Trim(fields[i+1], `"`), i+1
+			}
+		default:
+			rest = append(rest, f)
+		}
+	}
+	return strings.Join(rest, " "), switches
+}
+
+// resolveCodeRefs resolves the line numbers of b and adds its coderef labels to the CodeRefs of the document.
+func (d *Document) resolveCodeRefs(b *Block, rawText string) {
+	lines, s := strings.Split(strings.TrimSuffix(rawText, "\n"), "\n"), &b.Switches
+	switch s.NumberLines {
+	case "-n":
+		s.FirstLine = s.Start
+		if s.Start == 0 {
+			s.FirstLine = 1
+		}
+	case "+n":
+		s.FirstLine = d.lastLineNumber + s.Start
+		if s.Start == 0 {
+			s.FirstLine++
+		}
+	}
+	if s.NumberLines != "" {
+		d.lastLineNumber = s.FirstLine + len(lines) - 1
+	}
+	labelRegexp := codeRefLabelRegexp(s.LabelFormat)
+	for i, line := range lines {
+		if m := labelRegexp.FindStringSubmatch(line); m != nil {
+			if s.CodeRefBlock == 0 {
+				d.codeRefBlocks++
+				s.CodeRefBlock = d.codeRefBlocks
+			}
+			n := i + 1
+			if s.NumberLines != "" {
+				n = s.FirstLine + i
+			}
+			label := m[1]
+			old, ok := d.CodeRefs[label]
+			d.journal(func() {
+				if ok {
+					d.CodeRefs[label] = old
+				} else {
+					delete(d.CodeRefs, label)
+				}
+			})
+			d.CodeRefs[label] = CodeRef{n, s.RemoveLabels, s.CodeRefBlock}
+		}
+	}
+}
+
+// codeRefLabelRegexp returns a regexp matching coderef labels of the given format (default: (ref:%s)) at the end of a line.
+func codeRefLabelRegexp(format string) *regexp.Regexp {
+	if format == "" {
+		format = "(ref:%s)"
+	}
+	codeRefLabelRegexps.Lock()
+	defer codeRefLabelRegexps.Unlock()
+	if r, ok := codeRefLabelRegexps.m[format]; ok {
+		return r
+	}
+	r := regexp.MustCompile(`(?m)[ \t]*` + strings.Replace(regexp.QuoteMeta(format), "%s", `([-\w]+)`, 1) + `[ \t]*$`)
+	if len(codeRefLabelRegexps.m) < maxCodeRefLabelRegexps {
+		codeRefLabelRegexps.m[format] = r
+	}
+	return r
+}
+
+// codeRefID returns the id of the anchor of the coderef label in the block with the given CodeRefBlock.
+// Labels are only unique per block - so the index of the block is part of the id.
+func codeRefID(block int, label string) string {
+	return fmt.Sprintf("coderef-%d-%s", block, label)
 }
 
 func trimIndentUpTo(max int) func(string) string {
@@ -145,6 +300,132 @@ func (b Block) ParameterMap() map[string]string {
 	return m
 }
 
-func (n Example) String() string { return orgWriter.WriteNodesAsString(n) }
-func (n Block) String() string   { return orgWriter.WriteNodesAsString(n) }
-func (n Result) String() string  { return orgWriter.WriteNodesAsString(n) }
+// resolveHeaderArgs adds the inherited header arguments to the HeaderArgs of all src blocks in nodes.
+// In order of precedence header arguments are set on the #+BEGIN_SRC line, #+HEADER lines, :header-args:LANG: properties
+// and #+PROPERTY: header-args:LANG keywords and finally :header-args: properties and #+PROPERTY: header-args keywords.
+// Inner headlines override the properties of outer headlines - unless they use the PROPERTY+ syntax to extend them.
+func (d *Document) resolveHeaderArgs(nodes []Node) []Node {
+	return mapSrcBlocks(nodes, nil, false, func(b Block, headlines []*Headline, _ bool) Block {
+		if b.HeaderArgs == nil {
+			b.HeaderArgs = map[string]string{}
+		}
+		lang := ""
+		if len(b.Parameters) != 0 {
+			lang = b.Parameters[0]
+		}
+		for _, key := range []string{"HEADER-ARGS:" + strings.ToUpper(lang), "HEADER-ARGS"} {
+			value := ""
+			inherit := func(k, v string) {
+				if k == key {
+					value = v
+				} else if k == key+"+" {
+					value += " " + v
+				}
+			}
+			for _, property := range strings.Split(d.Get("PROPERTY"), "\n") {
+				kv := strings.SplitN(strings.TrimSpace(property)+" ", " ", 2)
+				inherit(strings.ToUpper(kv[0]), strings.TrimSpace(kv[1]))
+			}
+			for _, h := range headlines {
+				if h.Properties != nil {
+					for _, kv := range h.Properties.Properties {
+						inherit(kv[0], kv[1])
+					}
+				}
+			}
+			parameters := splitParameters(" " + value)
+			for i := 0; i+1 < len(parameters); i += 2 {
+				if _, ok := b.HeaderArgs[parameters[i]]; !ok {
+					b.HeaderArgs[parameters[i]] = parameters[i+1]
+				}
+			}
+		}
+		return b
+	})
+}
+
+// isCommented returns true if one of the headlines is commented out (i.e. its title starts with COMMENT).
+func isCommented(headlines []*Headline) bool {
+	for _, h := range headlines {
+		if len(h.Title) != 0 && strings.HasPrefix(String(h.Title), "COMMENT ") {
+			return true
+		}
+	}
+	return false
+}
+
+// mapSrcBlocks calls f for all src blocks in nodes (with the enclosing headlines and whether they are part of an
+// #+INCLUDE) and replaces them with the returned block. nodes are updated in place - use walkSrcBlocks to only read them.
+func mapSrcBlocks(nodes []Node, headlines []*Headline, included bool, f func(b Block, headlines []*Headline, included bool) Block) []Node {
+	for i, n := range nodes {
+		switch n := n.(type) {
+		case Headline:
+			n.Children = mapSrcBlocks(n.Children, append(headlines[:len(headlines):len(headlines)], &n), included, f)
+			nodes[i] = n
+		case Block:
+			if n.Name == "SRC" {
+				nodes[i] = f(n, headlines, included)
+			} else if !isLineBlock(n.Name) {
+				n.Children = mapSrcBlocks(n.Children, headlines, included, f)
+				nodes[i] = n
+			}
+		case NodeWithName:
+			n.Node = mapSrcBlocks([]Node{n.Node}, headlines, included, f)[0]
+			nodes[i] = n
+		case NodeWithMeta:
+			n.Node = mapSrcBlocks([]Node{n.Node}, headlines, included, f)[0]
+			nodes[i] = n
+		case Include:
+			n.Children = mapSrcBlocks(n.Children, headlines, true, f)
+			nodes[i] = n
+		case List:
+			n.Items = mapSrcBlocks(n.Items, headlines, included, f)
+			nodes[i] = n
+		case ListItem:
+			n.Children = mapSrcBlocks(n.Children, headlines, included, f)
+			nodes[i] = n
+		case DescriptiveListItem:
+			n.Details = mapSrcBlocks(n.Details, headlines, included, f)
+			nodes[i] = n
+		case Drawer:
+			n.Children = mapSrcBlocks(n.Children, headlines, included, f)
+			nodes[i] = n
+		}
+	}
+	return nodes
+}
+
+// walkSrcBlocks calls f for all src blocks in nodes (with the enclosing headlines) without modifying nodes -
+// i.e. it can be used on the nodes of a document that is shared with writers.
+func walkSrcBlocks(nodes []Node, headlines []*Headline, f func(b Block, headlines []*Headline)) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case Headline:
+			walkSrcBlocks(n.Children, append(headlines[:len(headlines):len(headlines)], &n), f)
+		case Block:
+			if n.Name == "SRC" {
+				f(n, headlines)
+			} else if !isLineBlock(n.Name) {
+				walkSrcBlocks(n.Children, headlines, f)
+			}
+		case NodeWithName:
+			walkSrcBlocks([]Node{n.Node}, headlines, f)
+		case NodeWithMeta:
+			walkSrcBlocks([]Node{n.Node}, headlines, f)
+		case Include:
+			walkSrcBlocks(n.Children, headlines, f)
+		case List:
+			walkSrcBlocks(n.Items, headlines, f)
+		case ListItem:
+			walkSrcBlocks(n.Children, headlines, f)
+		case DescriptiveListItem:
+			walkSrcBlocks(n.Details, headlines, f)
+		case Drawer:
+			walkSrcBlocks(n.Children, headlines, f)
+		}
+	}
+}
+
+func (n Example) String() string { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Block) String() string   { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Result) String() string  { return NewOrgWriter().WriteNodesAsString(n) }
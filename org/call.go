@@ -0,0 +1,175 @@
+package org
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Call is a #+CALL: NAME[INSIDE-HEADER](ARGUMENTS)[END-HEADER] line. It evaluates the src block named NAME.
+type Call struct {
+	Name         string
+	InsideHeader string // InsideHeader contains header arguments for the called src block.
+	Arguments    string
+	EndHeader    string // EndHeader contains header arguments for the result of the call (e.g. :results table).
+	Result       Node   // Result is the cached #+RESULTS: of the call.
+}
+
+// InlineCall is an inline call_NAME[INSIDE-HEADER](ARGUMENTS)[END-HEADER].
+type InlineCall struct {
+	Name         string
+	InsideHeader string
+	Arguments    string
+	EndHeader    string
+	Result       []Node // Result is the cached result - i.e. the content of a {{{results(...)}}} macro following the call.
+}
+
+var callRegexp = regexp.MustCompile(`^([^\s\[\]()]+)(?:\[([^\]]*)\])?(?:\(([^)]*)\))?(?:\[([^\]]*)\])?\s*$`)
+var inlineCallRegexp = regexp.MustCompile(`^call_([^\s\[\]()]+)(?:\[([^\]]*)\])?\(([^)]*)\)(?:\[([^\]]*)\])?`)
+var inlineResultRegexp = regexp.MustCompile(`^ {{{results\((.*?)\)}}}`)
+
+func (d *Document) parseCall(k Keyword, i int, stop stopFn) (int, Node) {
+	m := callRegexp.FindStringSubmatch(k.Value)
+	if m == nil {
+		d.Log.Printf("Bad call %#v", k)
+		return 1, k
+	}
+	consumed, result := d.parseSrcBlockResult(i+1, stop)
+	return consumed + 1, Call{m[1], m[2], m[3], m[4], result}
+}
+
+func (d *Document) parseInlineCall(input string, start int) (int, int, Node) {
+	if !(strings.HasSuffix(input[:start], "call") && (start-5 < 0 || unicode.IsSpace(rune(input[start-5])))) {
+		return 0, 0, nil
+	}
+	m := inlineCallRegexp.FindStringSubmatch(input[start-4:])
+	if m == nil {
+		return 0, 0, nil
+	}
+	consumed, result := d.parseInlineResult(input, start-4+len(m[0]))
+	return 4, len(m[0]) + consumed, InlineCall{m[1], m[2], m[3], m[4], result}
+}
+
+// parseInlineResult parses the cached result of an inline call_ or src_ block at input[start:] - i.e. {{{results(...)}}}.
+func (d *Document) parseInlineResult(input string, start int) (int, []Node) {
+	if m := inlineResultRegexp.FindStringSubmatch(input[start:]); m != nil {
+		return len(m[0]), d.parseInline(m[1])
+	}
+	return 0, nil
+}
+
+// evaluateCall evaluates the src block named name using Configuration.Evaluate.
+// It returns the output of the evaluation and the effective header arguments.
+func (d *Document) evaluateCall(name, insideHeader, arguments, endHeader string) (string, map[string]string, bool) {
+	if d.Evaluate == nil {
+		return "", nil, false
+	}
+	node := d.NamedNodes[name]
+	if n, ok := node.(NodeWithMeta); ok {
+		node = n.Node
+	}
+	b, ok := node.(Block)
+	if !ok || b.Name != "SRC" {
+		d.Log.Printf("Bad call: no src block named %s", name)
+		return "", nil, false
+	}
+	headerArgs := map[string]string{}
+	for k, v := range b.HeaderArgs {
+		headerArgs[k] = v
+	}
+	for _, header := range []string{insideHeader, endHeader} {
+		parameters := splitParameters(" " + header)
+		for i := 0; i+1 < len(parameters); i += 2 {
+			headerArgs[parameters[i]] = parameters[i+1]
+		}
+	}
+	b.HeaderArgs = headerArgs
+	output, ok := d.evaluateBlock(b, arguments)
+	return output, headerArgs, ok
+}
+
+// evaluateBlock evaluates b using Configuration.Evaluate. The :var header arguments of b are overridden by arguments.
+func (d *Document) evaluateBlock(b Block, arguments string) (string, bool) {
+	if d.Evaluate == nil {
+		return "", false
+	}
+	defaults, vars := parseVariables(b.HeaderArgs[":var"]), map[string]string{}
+	for _, kv := range defaults {
+		vars[kv[0]] = kv[1]
+	}
+	for i, kv := range parseVariables(arguments) {
+		if kv[0] == "" && i < len(defaults) {
+			kv[0] = defaults[i][0]
+		}
+		if kv[0] != "" {
+			vars[kv[0]] = kv[1]
+		}
+	}
+	output, err := d.Evaluate(d.Context(), b, vars)
+	if err != nil {
+		d.Log.Printf("Bad evaluation: %s", err)
+		return "", false
+	}
+	return output, true
+}
+
+// parseVariables parses comma separated assignments (NAME=VALUE) - e.g. the arguments of a call or :var header arguments.
+// Positional arguments (i.e. without NAME=) are returned with an empty name.
+func parseVariables(s string) [][2]string {
+	parts, current, quoted := []string{}, "", false
+	for _, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ',' && !quoted {
+			parts, current = append(parts, current), ""
+			continue
+		}
+		current += string(r)
+	}
+	vars := [][2]string{}
+	for _, p := range append(parts, current) {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 && !strings.Contains(kv[0], `"`) {
+			vars = append(vars, [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+		} else {
+			vars = append(vars, [2]string{"", p})
+		}
+	}
+	return vars
+}
+
+// writeCall writes the result of evaluating c (see Configuration.Evaluate) - or its cached result.
+func writeCall(w Writer, d *Document, c Call) {
+	output, headerArgs, ok := d.evaluateCall(c.Name, c.InsideHeader, c.Arguments, c.EndHeader)
+	if !ok {
+		if c.Result != nil {
+			WriteNodes(w, c.Result)
+		}
+		return
+	}
+	results := map[string]bool{}
+	for _, r := range strings.Fields(headerArgs[":results"]) {
+		results[r] = true
+	}
+	if !results["silent"] && !results["none"] {
+		WriteNodes(w, Result{Node: d.parseExecutionResult(output, results["table"] && !results["verbatim"])})
+	}
+}
+
+// writeInlineCall writes the result of evaluating c (see Configuration.Evaluate) - or its cached result.
+func writeInlineCall(w Writer, d *Document, c InlineCall) {
+	if output, _, ok := d.evaluateCall(c.Name, c.InsideHeader, c.Arguments, c.EndHeader); ok {
+		WriteNodes(w, inlineResult(output)...)
+	} else {
+		WriteNodes(w, c.Result...)
+	}
+}
+
+func inlineResult(output string) []Node {
+	return []Node{Emphasis{"=", []Node{Text{strings.TrimSpace(output), false}}}}
+}
+
+func (n Call) String() string       { return NewOrgWriter().WriteNodesAsString(n) }
+func (n InlineCall) String() string { return NewOrgWriter().WriteNodesAsString(n) }
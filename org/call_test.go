@@ -0,0 +1,72 @@
+package org
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	input := `#+NAME: greet
+#+BEGIN_SRC sh :var name="world", greeting=hello :exports none
+echo "$greeting $name"
+#+END_SRC
+
+#+CALL: greet(name="go-org")
+
+#+RESULTS:
+: stale
+
+- inline: call_greet("you", hi)
+- src: src_sh{echo inline}
+`
+	expected := `<pre class="example">
+hello go-org
+</pre>
+<ul>
+<li>inline: <code class="verbatim">hi you</code></li>
+<li>src: <code class="verbatim">inline</code></li>
+</ul>
+`
+	config := New().Silent()
+	config.Evaluate = func(ctx context.Context, b Block, vars map[string]string) (string, error) {
+		if b.HeaderArgs[":lang"] != "sh" {
+			t.Errorf("expected sh src block: %#v", b)
+		}
+		if strings.Contains(String(b.Children), "inline") {
+			return "inline\n", nil
+		}
+		return strings.Trim(vars["greeting"], `"`) + " " + strings.Trim(vars["name"], `"`) + "\n", nil
+	}
+	actual, err := config.Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+	for _, w := range []Writer{NewTextWriter(), NewManWriter()} {
+		actual, err := config.Parse(strings.NewReader(input), "").Write(w)
+		if err != nil {
+			t.Fatalf("got error: %s", err)
+		}
+		if !strings.Contains(actual, "hello go") || !strings.Contains(actual, "hi you") || strings.Contains(actual, "stale") {
+			t.Errorf("%T: expected the calls to be evaluated:\n%s", w, actual)
+		}
+	}
+}
+
+func TestCallsWithoutCallWriter(t *testing.T) {
+	type writerWithoutCalls struct{ Writer }
+	input := "#+CALL: f()\n\n#+RESULTS:\n: cached\n\n#+CALL: g()\n\ninline call_f() {{{results(=result=)}}}\n"
+	htmlWriter := NewHTMLWriter()
+	htmlWriter.ExtendingWriter = writerWithoutCalls{htmlWriter}
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(htmlWriter)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	expected := "<pre class=\"example\">\ncached\n</pre>\n#+CALL: g()\n<p>\ninline <code class=\"verbatim\">result</code></p>\n"
+	if actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
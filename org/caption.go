@@ -0,0 +1,160 @@
+package org
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Captioned is a captioned element (see Document.Captions). Captioned elements are numbered by kind.
+type Captioned struct {
+	Kind    string // Kind is one of figure, table or listing (src blocks).
+	Number  int
+	Name    string // Name is the #+NAME: of the element - if any.
+	Caption []Node
+}
+
+// captionLabels contains the localized labels for captioned elements and lists of them (see #+TOC: tables) by #+LANGUAGE.
+var captionLabels = map[string]map[string]string{
+	"en": {"figure": "Figure", "table": "Table", "listing": "Listing", "tables": "List of Tables", "listings": "List of Listings"},
+	"de": {"figure": "Abbildung", "table": "Tabelle", "listing": "Programmlisting", "tables": "Tabellenverzeichnis", "listings": "Programmlistings"},
+	"es": {"figure": "Figura", "table": "Tabla", "listing": "Listado de programa", "tables": "Índice de tablas", "listings": "Índice de listados de programas"},
+	"fr": {"figure": "Figure", "table": "Tableau", "listing": "Programme", "tables": "Liste des tableaux", "listings": "Liste des programmes"},
+	"it": {"figure": "Figura", "table": "Tabella", "listing": "Listato", "tables": "Indice delle tabelle", "listings": "Indice dei listati"},
+	"nl": {"figure": "Figuur", "table": "Tabel", "listing": "Programma", "tables": "Lijst van tabellen", "listings": "Lijst van programma's"},
+	"pt": {"figure": "Figura", "table": "Tabela", "listing": "Listagem", "tables": "Índice de tabelas", "listings": "Índice de listagens"},
+}
+
+// CaptionKind returns the kind of the element for numbering (see Captioned).
+func (n NodeWithMeta) CaptionKind() string {
+	switch n := n.Node.(type) {
+	case Table:
+		return "table"
+	case Block:
+		if n.Name == "SRC" {
+			return "listing"
+		}
+	}
+	return "figure"
+}
+
+// ID returns the id of the captioned element - its name or KIND-NUMBER.
+func (c Captioned) ID() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s-%d", c.Kind, c.Number)
+}
+
+func (d *Document) addCaptioned(n NodeWithMeta) NodeWithMeta {
+	if len(n.Meta.Caption) == 0 {
+		return n
+	}
+	kind, caption := n.CaptionKind(), []Node{}
+	for i, nodes := range n.Meta.Caption {
+		if i != 0 {
+			caption = append(caption, Text{" ", false})
+		}
+		caption = append(caption, nodes...)
+	}
+	n.Meta.captioned = &Captioned{kind, 0, "", caption}
+	d.Captions = append(d.Captions, n.Meta.captioned)
+	return n
+}
+
+// numberCaptions numbers the captioned elements in document order once parsing is done - skipping those
+// below excluded headlines (see EXCLUDE_TAGS) as they are not exported. Captions then only contains the numbered ones.
+// Documents parsed during writing (see parseSubDocument) continue the numbering of the document they are written into.
+func (d *Document) numberCaptions() {
+	numbers := map[string]int{}
+	if d.root != nil {
+		for _, c := range d.root.Captions {
+			numbers[c.Kind]++
+		}
+		for kind, n := range d.root.writing().captionNumbers {
+			numbers[kind] += n
+		}
+	}
+	d.Captions = nil
+	d.numberCaptionsIn(d.Nodes, numbers)
+	if d.root != nil {
+		write := d.root.writing()
+		if write.captionNumbers == nil {
+			write.captionNumbers = map[string]int{}
+		}
+		for _, c := range d.Captions {
+			write.captionNumbers[c.Kind]++
+		}
+	}
+}
+
+// numberCaptionsIn numbers the captioned elements in nodes (see numberCaptions). nodes are updated in place.
+func (d *Document) numberCaptionsIn(nodes []Node, numbers map[string]int) {
+	for i, n := range nodes {
+		switch n := n.(type) {
+		case Headline:
+			if !n.IsExcluded(d) {
+				d.numberCaptionsIn(n.Children, numbers)
+			}
+		case NodeWithMeta:
+			if c := n.Meta.captioned; c != nil {
+				numbers[c.Kind]++
+				c.Number, n.Meta.Number = numbers[c.Kind], numbers[c.Kind]
+				d.Captions = append(d.Captions, c)
+			}
+			children := []Node{n.Node}
+			d.numberCaptionsIn(children, numbers)
+			n.Node = children[0]
+			nodes[i] = n
+		case NodeWithName:
+			children := []Node{n.Node}
+			d.numberCaptionsIn(children, numbers)
+			n.Node = children[0]
+			nodes[i] = n
+		case Block:
+			if !isLineBlock(n.Name) {
+				d.numberCaptionsIn(n.Children, numbers)
+			}
+		case Include:
+			d.numberCaptionsIn(n.Children, numbers)
+		case List:
+			d.numberCaptionsIn(n.Items, numbers)
+		case ListItem:
+			d.numberCaptionsIn(n.Children, numbers)
+		case DescriptiveListItem:
+			d.numberCaptionsIn(n.Details, numbers)
+		case Drawer:
+			d.numberCaptionsIn(n.Children, numbers)
+		case FootnoteDefinition:
+			d.numberCaptionsIn(n.Children, numbers)
+		}
+	}
+}
+
+// captioned returns the numbered captioned element of n - or nil.
+func (n NodeWithMeta) captioned() *Captioned {
+	if c := n.Meta.captioned; c != nil && c.Number != 0 {
+		return c
+	}
+	return nil
+}
+
+// namedCaptioned returns the captioned element with the #+NAME: name - or nil.
+func (d *Document) namedCaptioned(name string) *Captioned {
+	for _, c := range d.Captions {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// captionLabel returns the label for key (e.g. figure or tables) in the #+LANGUAGE of the document.
+func (d *Document) captionLabel(key string) string {
+	language := strings.ToLower(d.Get("LANGUAGE"))
+	if labels, ok := captionLabels[language]; ok {
+		return labels[key]
+	} else if labels, ok := captionLabels[strings.SplitN(language, "-", 2)[0]]; ok {
+		return labels[key]
+	}
+	return captionLabels["en"][key]
+}
@@ -0,0 +1,526 @@
+package org
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Citation is an Org mode citation - e.g. [cite/t:see @knuth84 p. 5;@lamport94].
+type Citation struct {
+	Style      string // Style is the citation style - e.g. t (text), a (author), na (noauthor), y (year) or n (nocite).
+	Variant    string // Variant is the style variant - e.g. b (bare) in [cite/t/b:@key].
+	Prefix     string // Prefix is the global prefix of the citation - i.e. the text before the first reference.
+	Suffix     string // Suffix is the global suffix of the citation - i.e. the text after the last reference.
+	References []CitationReference
+}
+
+// CitationReference is a single cited key with its prefix and suffix - e.g. "see @knuth84 p. 5".
+type CitationReference struct {
+	Key     string
+	Prefix  string
+	Suffix  string
+	Locator string // Locator is the locator at the start of the suffix - e.g. p. 5.
+}
+
+// BibliographyEntry is an entry of a #+BIBLIOGRAPHY: file.
+type BibliographyEntry struct {
+	Key     string
+	Type    string            // Type is the (BibTeX) type of the entry - e.g. article or book.
+	Authors []Name            // Authors contains the authors - or the editors if there are no authors.
+	Fields  map[string]string // Fields contains the lowercase BibTeX fields (e.g. title, year, journal). CSL-JSON variables are mapped to them.
+}
+
+// Name is the name of an author or editor.
+type Name struct {
+	Family string
+	Given  string
+}
+
+var citationRegexp = regexp.MustCompile(`^\[cite(?:/([\w-]+))?(?:/([\w-]+))?:([^\]]*)\]`)
+var citationKeyRegexp = regexp.MustCompile("(?:^|\\s)@([\\w!#$%&*+./:<=>?^|~'`-]+)")
+var citationLocatorRegexp = regexp.MustCompile(`(?i)^,?\s*((?:p|pp|page|pages|chap|chapter|sec|section|vol|volume|fig|figure|no|number|para|paragraph|l|line|n|note)\.?\s*[\w–-]+)`)
+
+var bibtexNameSeparatorRegexp = regexp.MustCompile(`\s+and\s+`)
+
+var cslTypes = map[string]string{
+	"article-journal":  "article",
+	"article-magazine": "article",
+	"article":          "article",
+	"book":             "book",
+	"chapter":          "incollection",
+	"paper-conference": "inproceedings",
+	"thesis":           "phdthesis",
+	"report":           "techreport",
+	"webpage":          "online",
+}
+
+var bibtexReplacer = strings.NewReplacer(`\&`, "&", `\%`, "%", `\$`, "$", `\_`, "_", `\#`, "#", "---", "—", "--", "–", "~", " ")
+
+func (d *Document) parseCitation(input string, start int) (int, Node) {
+	m := citationRegexp.FindStringSubmatch(input[start:])
+	if m == nil {
+		return 0, nil
+	}
+	c, parts, keys := Citation{Style: m[1], Variant: m[2]}, strings.Split(m[3], ";"), []string{}
+	for i, part := range parts {
+		loc := citationKeyRegexp.FindStringSubmatchIndex(part)
+		if loc == nil {
+			if i == 0 && len(parts) > 1 {
+				c.Prefix = part
+			} else if i == len(parts)-1 && len(c.References) != 0 {
+				c.Suffix = part
+			} else {
+				return 0, nil
+			}
+			continue
+		}
+		reference := CitationReference{Key: part[loc[2]:loc[3]], Prefix: part[:loc[2]-1], Suffix: part[loc[3]:]}
+		if m := citationLocatorRegexp.FindStringSubmatch(strings.TrimSpace(reference.Suffix)); m != nil {
+			reference.Locator = m[1]
+		}
+		c.References = append(c.References, reference)
+		keys = append(keys, reference.Key)
+	}
+	d.citedKeys = append(d.citedKeys, keys...)
+	return len(m[0]), c
+}
+
+func (d *Document) loadBibliography(k Keyword, i int) {
+	path, err := d.resolvePath(k.Value)
+	if err != nil {
+		d.Log.Printf("Bad bibliography: %#v: %s", k, err)
+		d.addFileDiagnostic(d.line(i), k, err)
+		return
+	}
+	bs, err := d.readFile(path)
+	if err != nil {
+		d.Log.Printf("Bad bibliography: %#v: %s", k, err)
+		d.addFileDiagnostic(d.line(i), k, err)
+		return
+	}
+	var entries []*BibliographyEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		entries, err = parseCSLJSON(bs)
+	case ".bib", ".bibtex":
+		entries, err = parseBibTeX(string(bs))
+	default:
+		err = fmt.Errorf("unsupported format (expected .bib or .json)")
+	}
+	if err != nil {
+		d.Log.Printf("Bad bibliography: %#v: %s", k, err)
+		return
+	}
+	for _, e := range entries {
+		key := e.Key
+		old, ok := d.Bibliography[key]
+		d.journal(func() {
+			if ok {
+				d.Bibliography[key] = old
+			} else {
+				delete(d.Bibliography, key)
+			}
+		})
+		d.Bibliography[key] = e
+	}
+}
+
+// parseBibTeX parses the entries of a BibTeX file. @string macros are not expanded.
+func parseBibTeX(s string) ([]*BibliographyEntry, error) {
+	entries := []*BibliographyEntry{}
+	for {
+		i := strings.IndexByte(s, '@')
+		if i == -1 {
+			return entries, nil
+		}
+		s = s[i+1:]
+		j := strings.IndexAny(s, "{(")
+		if j == -1 {
+			return entries, nil
+		}
+		kind := strings.ToLower(strings.TrimSpace(s[:j]))
+		body, rest, ok := bibtexGroup(s[j:])
+		if !ok {
+			return nil, fmt.Errorf("unbalanced braces in @%s entry", kind)
+		}
+		s = rest
+		if kind == "comment" || kind == "string" || kind == "preamble" {
+			continue
+		}
+		k := strings.IndexByte(body, ',')
+		if k == -1 {
+			continue
+		}
+		fields, authors := parseBibTeXFields(body[k+1:]), []Name{}
+		for _, key := range []string{"author", "editor"} {
+			if value, ok := fields[key]; ok && len(authors) == 0 {
+				authors = parseBibTeXNames(value)
+			}
+		}
+		for key, value := range fields {
+			fields[key] = cleanBibTeX(value)
+		}
+		entries = append(entries, &BibliographyEntry{strings.TrimSpace(body[:k]), kind, authors, fields})
+	}
+}
+
+// bibtexGroup returns the content of the group (braces or parens) at the start of s and the rest of s.
+func bibtexGroup(s string) (string, string, bool) {
+	closing, depth := byte('}'), 0
+	if s[0] == '(' {
+		closing = ')'
+	}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == closing && depth == 0:
+			return s[1:i], s[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func parseBibTeXFields(s string) map[string]string {
+	fields := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t\r\n,")
+		i := strings.IndexByte(s, '=')
+		if i == -1 {
+			return fields
+		}
+		key, value := strings.ToLower(strings.TrimSpace(s[:i])), ""
+		s = strings.TrimLeft(s[i+1:], " \t\r\n")
+		for len(s) != 0 {
+			switch s[0] {
+			case '{':
+				content, rest, ok := bibtexGroup(s)
+				if !ok {
+					return fields
+				}
+				value, s = value+content, rest
+			case '"':
+				end, depth := 1, 0
+				for ; end < len(s) && (s[end] != '"' || depth != 0); end++ {
+					if s[end] == '{' {
+						depth++
+					} else if s[end] == '}' {
+						depth--
+					}
+				}
+				if end == len(s) {
+					return fields
+				}
+				value, s = value+s[1:end], s[end+1:]
+			default:
+				end := strings.IndexAny(s, ",#")
+				if end == -1 {
+					end = len(s)
+				}
+				value, s = value+strings.TrimSpace(s[:end]), s[end:]
+			}
+			if s = strings.TrimLeft(s, " \t\r\n"); len(s) == 0 || s[0] != '#' {
+				break
+			}
+			s = strings.TrimLeft(s[1:], " \t\r\n")
+		}
+		fields[key] = value
+	}
+}
+
+func parseBibTeXNames(s string) []Name {
+	names := []Name{}
+	for _, name := range bibtexNameSeparatorRegexp.Split(strings.TrimSpace(s), -1) {
+		if name == "others" || name == "" {
+			continue
+		} else if strings.HasPrefix(name, "{") && strings.HasSuffix(name, "}") {
+			names = append(names, Name{Family: cleanBibTeX(name)})
+		} else if parts := strings.SplitN(name, ",", 2); len(parts) == 2 {
+			names = append(names, Name{cleanBibTeX(parts[0]), cleanBibTeX(parts[1])})
+		} else {
+			words := strings.Fields(cleanBibTeX(name))
+			if len(words) == 0 {
+				continue
+			}
+			names = append(names, Name{words[len(words)-1], strings.Join(words[:len(words)-1], " ")})
+		}
+	}
+	return names
+}
+
+func cleanBibTeX(s string) string {
+	s = strings.NewReplacer("{", "", "}", "").Replace(s)
+	return strings.Join(strings.Fields(bibtexReplacer.Replace(s)), " ")
+}
+
+// parseCSLJSON parses the entries of a CSL-JSON file.
+func parseCSLJSON(bs []byte) ([]*BibliographyEntry, error) {
+	items := []map[string]interface{}{}
+	if err := json.Unmarshal(bs, &items); err != nil {
+		return nil, err
+	}
+	entries := []*BibliographyEntry{}
+	for _, item := range items {
+		kind, fields := fmt.Sprint(item["type"]), map[string]string{}
+		if t, ok := cslTypes[kind]; ok {
+			kind = t
+		}
+		containerField := "booktitle"
+		if kind == "article" {
+			containerField = "journal"
+		}
+		for variable, field := range map[string]string{"title": "title", "container-title": containerField, "publisher": "publisher",
+			"volume": "volume", "issue": "number", "page": "pages", "URL": "url", "DOI": "doi"} {
+			if value, ok := item[variable]; ok {
+				fields[field] = strings.ReplaceAll(fmt.Sprint(value), "--", "–")
+			}
+		}
+		if issued, ok := item["issued"].(map[string]interface{}); ok {
+			if parts, ok := issued["date-parts"].([]interface{}); ok && len(parts) != 0 {
+				if date, ok := parts[0].([]interface{}); ok && len(date) != 0 {
+					fields["year"] = fmt.Sprint(date[0])
+				}
+			} else if literal, ok := issued["literal"]; ok {
+				fields["year"] = fmt.Sprint(literal)
+			}
+		}
+		authors := []Name{}
+		for _, key := range []string{"author", "editor"} {
+			names, _ := item[key].([]interface{})
+			for _, n := range names {
+				if n, ok := n.(map[string]interface{}); ok {
+					if literal, ok := n["literal"]; ok {
+						authors = append(authors, Name{Family: fmt.Sprint(literal)})
+					} else {
+						family, _ := n["family"].(string)
+						given, _ := n["given"].(string)
+						authors = append(authors, Name{family, given})
+					}
+				}
+			}
+			if len(authors) != 0 {
+				break
+			}
+		}
+		entries = append(entries, &BibliographyEntry{fmt.Sprint(item["id"]), kind, authors, fields})
+	}
+	return entries, nil
+}
+
+// citationMarkup is used to format citations and bibliographies for a specific output format.
+type citationMarkup struct {
+	escape   func(string) string
+	emphasis func(string) string           // emphasis marks up escaped text - e.g. the title of a journal.
+	link     func(url, text string) string // link marks up escaped text as a link to url (e.g. #citation-KEY).
+}
+
+// citationStyle returns the bibliography style of #+CITE_EXPORT: basic STYLE - author-year (default) or numeric.
+func (d *Document) citationStyle() string {
+	if fields := strings.Fields(d.Get("CITE_EXPORT")); len(fields) >= 2 && fields[1] == "numeric" {
+		return "numeric"
+	}
+	return "author-year"
+}
+
+// citedEntries returns the cited bibliography entries in the order of their first citation and their (numeric style)
+// numbers by key. [cite/n:@*] cites all entries of the bibliography. They are computed once per write.
+func (d *Document) citedEntries() ([]*BibliographyEntry, map[string]int) {
+	write := d.writing()
+	if write.citationNumbers != nil {
+		return write.citedEntries, write.citationNumbers
+	}
+	entries, seen := []*BibliographyEntry{}, map[string]bool{}
+	for _, key := range d.citedKeys {
+		if e, ok := d.Bibliography[key]; ok && !seen[key] {
+			entries, seen[key] = append(entries, e), true
+		} else if key == "*" {
+			keys := make([]string, 0, len(d.Bibliography))
+			for key := range d.Bibliography {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if !seen[key] {
+					entries, seen[key] = append(entries, d.Bibliography[key]), true
+				}
+			}
+		}
+	}
+	numbers := make(map[string]int, len(entries))
+	for i, e := range entries {
+		numbers[e.Key] = i + 1
+	}
+	write.citedEntries, write.citationNumbers = entries, numbers
+	return entries, numbers
+}
+
+func citationID(key string) string { return "citation-" + key }
+
+func (d *Document) formatCitation(c Citation, m citationMarkup) string {
+	style := d.citationStyle()
+	if c.Style == "n" || c.Style == "nocite" {
+		return ""
+	}
+	_, numbers := d.citedEntries()
+	references := []string{}
+	for _, r := range c.References {
+		e, ok := d.Bibliography[r.Key]
+		if !ok {
+			d.Log.Printf("Missing bibliography entry for citation key %s", r.Key)
+			references = append(references, m.escape(withPrefix(r.Prefix)+"@"+r.Key+withSuffix(r.Suffix)))
+			continue
+		}
+		author, year, number := m.escape(e.shortAuthors()), m.escape(e.year()), strconv.Itoa(numbers[r.Key])
+		prefix, suffix, link := m.escape(withPrefix(r.Prefix)), m.escape(withSuffix(r.Suffix)), func(text string) string {
+			return m.link("#"+citationID(r.Key), text)
+		}
+		switch {
+		case c.Style == "a" || c.Style == "author":
+			references = append(references, prefix+link(author)+suffix)
+		case (c.Style == "t" || c.Style == "text") && style == "numeric":
+			references = append(references, prefix+author+" ["+link(number)+suffix+"]")
+		case c.Style == "t" || c.Style == "text":
+			references = append(references, prefix+link(author)+" ("+year+suffix+")")
+		case style == "numeric":
+			references = append(references, prefix+link(number)+suffix)
+		case c.Style == "na" || c.Style == "noauthor" || c.Style == "y" || c.Style == "year":
+			references = append(references, prefix+link(year)+suffix)
+		default:
+			references = append(references, prefix+link(author+", "+year)+suffix)
+		}
+	}
+	out := m.escape(withPrefix(c.Prefix))
+	switch {
+	case c.Style == "a" || c.Style == "author" || c.Style == "t" || c.Style == "text" || c.Style == "y" || c.Style == "year":
+		out += strings.Join(references, ", ")
+	case style == "numeric":
+		out += "[" + strings.Join(references, ", ") + "]"
+	default:
+		out += strings.Join(references, "; ")
+	}
+	out += m.escape(withSuffix(c.Suffix))
+	if style == "author-year" && (c.Style == "" || c.Style == "na" || c.Style == "noauthor") && c.Variant != "b" && c.Variant != "bare" {
+		out = "(" + out + ")"
+	}
+	return out
+}
+
+// formatBibliography returns the formatted entries of the bibliography by key - in order of citation for the numeric
+// style and sorted by author and year otherwise.
+func (d *Document) formatBibliography(m citationMarkup) [][2]string {
+	style, cited := d.citationStyle(), []*BibliographyEntry{}
+	entries, _ := d.citedEntries()
+	entries = append(cited, entries...) // the entries are cached for the write and must not be sorted in place
+	if style != "numeric" {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].shortAuthors()+entries[i].year() < entries[j].shortAuthors()+entries[j].year()
+		})
+	}
+	out := make([][2]string, len(entries))
+	for i, e := range entries {
+		parts, title := []string{}, m.escape(e.Fields["title"])
+		if e.Fields["journal"] == "" && e.Fields["booktitle"] == "" && title != "" {
+			title = m.emphasis(title)
+		}
+		authors := m.escape(e.longAuthors())
+		if style == "numeric" {
+			parts = append(parts, fmt.Sprintf("[%d]", i+1), authors+".")
+		} else {
+			parts = append(parts, authors, "("+m.escape(e.year())+").")
+		}
+		if title != "" {
+			parts = append(parts, title+".")
+		}
+		container := ""
+		for _, key := range []string{"journal", "booktitle"} {
+			if e.Fields[key] != "" && container == "" {
+				container = m.emphasis(m.escape(e.Fields[key]))
+			}
+		}
+		if container != "" {
+			if e.Fields["volume"] != "" {
+				container += ", " + m.escape(e.Fields["volume"])
+				if e.Fields["number"] != "" {
+					container += "(" + m.escape(e.Fields["number"]) + ")"
+				}
+			}
+			if e.Fields["pages"] != "" {
+				container += ", " + m.escape(e.Fields["pages"])
+			}
+			parts = append(parts, container+".")
+		}
+		if publisher := e.Fields["publisher"]; publisher != "" && style == "numeric" {
+			parts = append(parts, m.escape(publisher)+", "+m.escape(e.year())+".")
+		} else if publisher != "" {
+			parts = append(parts, m.escape(publisher)+".")
+		} else if style == "numeric" {
+			parts = append(parts, m.escape(e.year())+".")
+		}
+		if doi := e.Fields["doi"]; doi != "" {
+			parts = append(parts, m.link("https://doi.org/"+doi, m.escape("https://doi.org/"+doi)))
+		} else if url := e.Fields["url"]; url != "" {
+			parts = append(parts, m.link(url, m.escape(url)))
+		}
+		out[i] = [2]string{e.Key, strings.Join(parts, " ")}
+	}
+	return out
+}
+
+func withPrefix(s string) string {
+	if s = strings.TrimSpace(s); s != "" {
+		return s + " "
+	}
+	return ""
+}
+
+func withSuffix(s string) string {
+	if s = strings.TrimSpace(s); s != "" && !strings.HasPrefix(s, ",") {
+		return ", " + s
+	}
+	return s
+}
+
+func (e *BibliographyEntry) year() string {
+	if year := e.Fields["year"]; year != "" {
+		return year
+	}
+	return "n.d."
+}
+
+// shortAuthors returns the family names of the authors for citations - e.g. Knuth or Knuth et al.
+func (e *BibliographyEntry) shortAuthors() string {
+	switch len(e.Authors) {
+	case 0:
+		return e.Fields["title"]
+	case 1:
+		return e.Authors[0].Family
+	case 2:
+		return e.Authors[0].Family + " and " + e.Authors[1].Family
+	default:
+		return e.Authors[0].Family + " et al."
+	}
+}
+
+// longAuthors returns the full names of the authors for the bibliography - e.g. Knuth, Donald E. and Lamport, Leslie.
+func (e *BibliographyEntry) longAuthors() string {
+	names := make([]string, len(e.Authors))
+	for i, n := range e.Authors {
+		names[i] = n.Family
+		if n.Given != "" {
+			names[i] += ", " + n.Given
+		}
+	}
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+func (n Citation) String() string { return NewOrgWriter().WriteNodesAsString(n) }
@@ -14,20 +14,38 @@ package org
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
 type Configuration struct {
-	MaxEmphasisNewLines int                                   // Maximum number of newlines inside an emphasis. See org-emphasis-regexp-components newline.
-	AutoLink            bool                                  // Try to convert text passages that look like hyperlinks into hyperlinks.
-	DefaultSettings     map[string]string                     // Default values for settings that are overriden by setting the same key in BufferSettings.
-	Log                 *log.Logger                           // Log is used to print warnings during parsing.
-	ReadFile            func(filename string) ([]byte, error) // ReadFile is used to read e.g. #+INCLUDE files.
+	MaxEmphasisNewLines int                                                        // Maximum number of newlines inside an emphasis. See org-emphasis-regexp-components newline.
+	AutoLink            bool                                                       // Try to convert text passages that look like hyperlinks into hyperlinks.
+	DefaultSettings     map[string]string                                          // Default values for settings that are overriden by setting the same key in BufferSettings.
+	Log                 *log.Logger                                                // Log is used to print warnings during parsing.
+	ReadFile            func(filename string) ([]byte, error)                      // ReadFile is used to read e.g. #+INCLUDE files.
+	FS                  fs.FS                                                      // FS is used instead of ReadFile if set. Paths must not escape the root of FS.
+	ReadFileContext     func(ctx context.Context, filename string) ([]byte, error) // ReadFileContext is used instead of ReadFile and FS if set. ctx is the context passed to ParseContext / WriteContext.
+	Extensions          Extensions                                                 // Extensions contains custom lexers and parsers for in-house syntax.
+	MaxIncludeDepth     int                                                        // Maximum depth of nested files (e.g. #+SETUPFILE). 0 means no limit.
+	MaxMacroDepth       int                                                        // Maximum depth of nested macro expansions. 0 means no limit.
+	MaxNestingDepth     int                                                        // Maximum nesting depth of elements (lists, blocks, emphasis, ...). 0 means no limit.
+	MaxInputSize        int                                                        // Maximum size of the input in bytes. 0 means no limit.
+	HeadlineIDs         HeadlineIDStrategy                                         // HeadlineIDs determines the ids of headlines without a CUSTOM_ID or ID property.
+	// Evaluate is used to evaluate #+CALL lines and inline call_ / src_ blocks during writing (e.g. Executor.Evaluate).
+	// vars contains the :var header arguments of the src block and the arguments of the call (values are not unquoted).
+	// If Evaluate is nil, the cached results are written instead.
+	Evaluate func(ctx context.Context, b Block, vars map[string]string) (string, error)
 }
 
 // Document contains the parsing results and a pointer to the Configuration.
@@ -43,6 +61,50 @@ type Document struct {
 	Outline        Outline           // Outline is a Table Of Contents for the document and contains all sections (headline + content).
 	BufferSettings map[string]string // Settings contains all settings that were parsed from keywords.
 	Error          error
+	Diagnostics    []Diagnostic                  // Diagnostics contains problems with single elements that were parsed as plain text instead or whose files could not be loaded (see WriteDiagnostics for writing).
+	CodeRefs       map[string]CodeRef            // CodeRefs contains the coderef labels of src and example blocks by label.
+	Captions       []*Captioned                  // Captions contains the captioned elements in document order.
+	Bibliography   map[string]*BibliographyEntry // Bibliography contains the entries of the #+BIBLIOGRAPHY: files by key.
+	includeDepth   int
+	includeStack   []string
+	headlineLvl    int
+	nestingDepth   int
+	lastLineNumber int
+	codeRefBlocks  int
+	footnotes      map[string]*FootnoteDefinition
+	citedKeys      []string
+	ctx            context.Context
+	write          *writeState
+	root           *Document // root is the document that is being written if this document was parsed during writing (see parseSubDocument).
+	undo           []func()
+	nodeLines      map[*Node]int
+	includeLine    int // includeLine is the line of the #+INCLUDE of the document whose included file is being parsed.
+}
+
+// writeState contains the state of a single write of a document. It is kept out of the parsed Document
+// (see WriteContext) so that the same document can be written multiple times - and concurrently.
+type writeState struct {
+	ctx             context.Context
+	macroDepth      int
+	macroCounters   map[string]int
+	diagnostics     []Diagnostic
+	line            int                  // line is the input line of the node that is currently being written - 0 if unknown.
+	captionNumbers  map[string]int       // captionNumbers counts the captioned elements of documents parsed during writing by kind.
+	citedEntries    []*BibliographyEntry // citedEntries and citationNumbers cache the results of Document.citedEntries.
+	citationNumbers map[string]int
+}
+
+// Diagnostic describes an element that could not be parsed or written and fell back to plain text -
+// or a keyword whose file could not be loaded (e.g. #+INCLUDE of a path outside of Configuration.FS, see ErrPathOutsideRoot).
+type Diagnostic struct {
+	Line    int // Line is the 1-based line number of the element in the input - 0 if unknown.
+	Message string
+}
+
+// LimitError is returned when the input exceeds one of the Max* limits of the Configuration.
+type LimitError struct {
+	Limit string
+	Max   int
 }
 
 // Node represents a parsed node of the document.
@@ -76,7 +138,9 @@ var lexFns = []lexFn{
 }
 
 var nilToken = token{"nil", -1, "", nil}
-var orgWriter = NewOrgWriter()
+
+// ErrPathOutsideRoot is returned when a file outside of Configuration.FS is requested (e.g. via #+INCLUDE: "../../etc/passwd").
+var ErrPathOutsideRoot = errors.New("path escapes root of file system")
 
 // New returns a new Configuration with (hopefully) sane defaults.
 func New() *Configuration {
@@ -86,21 +150,34 @@ func New() *Configuration {
 		DefaultSettings: map[string]string{
 			"TODO":         "TODO | DONE",
 			"EXCLUDE_TAGS": "noexport",
-			"OPTIONS":      "toc:t <:t e:t f:t pri:t todo:t tags:t title:t",
+			"OPTIONS":      "toc:t <:t e:t f:t pri:t todo:t tags:t title:t num:nil H:nil",
 		},
-		Log:      log.New(os.Stderr, "go-org: ", 0),
-		ReadFile: ioutil.ReadFile,
+		Log:             log.New(os.Stderr, "go-org: ", 0),
+		ReadFile:        ioutil.ReadFile,
+		MaxIncludeDepth: 10,
+		MaxMacroDepth:   10,
+		MaxNestingDepth: 256,
 	}
 }
 
 // String returns the pretty printed Org mode string for the given nodes (see OrgWriter).
-func String(nodes []Node) string { return orgWriter.WriteNodesAsString(nodes...) }
+func String(nodes []Node) string { return NewOrgWriter().WriteNodesAsString(nodes...) }
 
 // Write is called after with an instance of the Writer interface to export a parsed Document into another format.
 func (d *Document) Write(w Writer) (out string, err error) {
+	return d.WriteContext(context.Background(), w)
+}
+
+// WriteContext is like Write but aborts writing with ctx.Err() once ctx is done.
+// Cancellation is checked between nodes for the builtin writers and writers extending them (see ExtendingWriter).
+func (d *Document) WriteContext(ctx context.Context, w Writer) (out string, err error) {
 	defer func() {
 		if recovered := recover(); recovered != nil {
-			err = fmt.Errorf("could not write output: %s", recovered)
+			if recoveredErr, ok := recovered.(error); ok {
+				err = fmt.Errorf("could not write output: %w", recoveredErr)
+			} else {
+				err = fmt.Errorf("could not write output: %s", recovered)
+			}
 		}
 	}()
 	if d.Error != nil {
@@ -108,28 +185,56 @@ func (d *Document) Write(w Writer) (out string, err error) {
 	} else if d.Nodes == nil {
 		return "", fmt.Errorf("could not write output: parse was not called")
 	}
-	w.Before(d)
-	WriteNodes(w, d.Nodes...)
-	w.After(d)
+	writtenDocument := *d
+	writtenDocument.write = &writeState{ctx: ctx}
+	w.Before(&writtenDocument)
+	WriteNodes(w, writtenDocument.Nodes...)
+	w.After(&writtenDocument)
 	return w.String(), err
 }
 
 // Parse parses the input into an AST (and some other helpful fields like Outline).
 // To allow method chaining, errors are stored in document.Error rather than being returned.
 func (c *Configuration) Parse(input io.Reader, path string) (d *Document) {
+	return c.ParseContext(context.Background(), input, path)
+}
+
+// ParseContext is like Parse but aborts parsing once ctx is done - document.Error then wraps ctx.Err().
+// Cancellation is checked between tokens.
+func (c *Configuration) ParseContext(ctx context.Context, input io.Reader, path string) (d *Document) {
+	d = c.newDocument(path)
+	d.ctx = ctx
+	d.parse(input)
+	return d
+}
+
+func (c *Configuration) newDocument(path string) *Document {
 	outlineSection := &Section{}
-	d = &Document{
+	return &Document{
 		Configuration:  c,
 		Outline:        Outline{outlineSection, outlineSection, 0},
 		BufferSettings: map[string]string{},
 		NamedNodes:     map[string]Node{},
 		Links:          map[string]string{},
 		Macros:         map[string]string{},
+		CodeRefs:       map[string]CodeRef{},
+		footnotes:      map[string]*FootnoteDefinition{},
+		Bibliography:   map[string]*BibliographyEntry{},
 		Path:           path,
+		includeStack:   []string{path},
+		nodeLines:      map[*Node]int{},
+		ctx:            context.Background(),
 	}
+}
+
+func (d *Document) parse(input io.Reader) {
 	defer func() {
 		if recovered := recover(); recovered != nil {
-			d.Error = fmt.Errorf("could not parse input: %v", recovered)
+			if err, ok := recovered.(error); ok {
+				d.Error = fmt.Errorf("could not parse input: %w", err)
+			} else {
+				d.Error = fmt.Errorf("could not parse input: %v", recovered)
+			}
 		}
 	}()
 	if d.tokens != nil {
@@ -137,8 +242,34 @@ func (c *Configuration) Parse(input io.Reader, path string) (d *Document) {
 	}
 	d.tokenize(input)
 	_, nodes := d.parseMany(0, func(d *Document, i int) bool { return i >= len(d.tokens) })
-	d.Nodes = nodes
-	return d
+	d.Nodes = d.resolveHeaderArgs(nodes)
+	d.assignHeadlineIDs()
+	d.numberCaptions()
+}
+
+// parseSubDocument parses input (e.g. an expanded macro or the #+TITLE) while d is being written.
+// The resulting document continues the numbering of the captioned elements of d.
+func (d *Document) parseSubDocument(input string) *Document {
+	subDocument := d.Configuration.newDocument(d.Path)
+	subDocument.ctx, subDocument.root = d.Context(), d
+	subDocument.parse(strings.NewReader(input))
+	return subDocument
+}
+
+// parseFile parses the contents of a file referenced by d (e.g. #+SETUPFILE).
+// Exceeding a limit while doing so is not recoverable and aborts the parsing of d as well.
+func (d *Document) parseFile(input io.Reader, path string) *Document {
+	if max := d.MaxIncludeDepth; max > 0 && d.includeDepth >= max {
+		panic(&LimitError{"include depth", max})
+	}
+	fileDocument := d.Configuration.newDocument(path)
+	fileDocument.includeDepth, fileDocument.ctx = d.includeDepth+1, d.ctx
+	fileDocument.parse(input)
+	if err := (*LimitError)(nil); errors.As(fileDocument.Error, &err) {
+		panic(err)
+	}
+	d.checkContext()
+	return fileDocument
 }
 
 // Silent disables all logging of warnings during parsing.
@@ -149,15 +280,53 @@ func (c *Configuration) Silent() *Configuration {
 
 func (d *Document) tokenize(input io.Reader) {
 	d.tokens = []token{}
+	if max := d.MaxInputSize; max > 0 {
+		bs, err := ioutil.ReadAll(io.LimitReader(input, int64(max)+1))
+		if err != nil {
+			d.Error = fmt.Errorf("could not tokenize input: %s", err)
+			return
+		} else if len(bs) > max {
+			d.Error = fmt.Errorf("could not tokenize input: %w", &LimitError{"input size", max})
+			return
+		}
+		input = bytes.NewReader(bs)
+	}
 	scanner := bufio.NewScanner(input)
 	for scanner.Scan() {
-		d.tokens = append(d.tokens, tokenize(scanner.Text()))
+		d.checkContext()
+		d.tokens = append(d.tokens, d.tokenizeLine(scanner.Text()))
 	}
 	if err := scanner.Err(); err != nil {
 		d.Error = fmt.Errorf("could not tokenize input: %s", err)
 	}
 }
 
+// Context returns the context passed to ParseContext / WriteContext (context.Background() for Parse / Write).
+func (d *Document) Context() context.Context {
+	if d.write != nil {
+		return d.write.ctx
+	}
+	return d.ctx
+}
+
+// writing returns the state of the current write of d. Documents that are written without WriteContext
+// (i.e. by calling Writer.Before directly) get one on first use.
+func (d *Document) writing() *writeState {
+	if d.write == nil {
+		d.write = &writeState{ctx: d.ctx}
+	}
+	return d.write
+}
+
+// checkContext aborts parsing / writing if the context of the document is done.
+func (d *Document) checkContext() {
+	if ctx := d.Context(); ctx == nil {
+		return
+	} else if err := ctx.Err(); err != nil {
+		panic(err)
+	}
+}
+
 // Get returns the value for key in BufferSettings or DefaultSettings if key does not exist in the former
 func (d *Document) Get(key string) string {
 	if v, ok := d.BufferSettings[key]; ok {
@@ -179,6 +348,8 @@ func (d *Document) Get(key string) string {
 // - todo (export headline todo status)
 // - pri (export headline priority)
 // - tags (export headline tags)
+// - num (number headlines. an int limits the numbered org headline lvl)
+// - H (export headline levels. deeper headlines are exported as list items)
 // see https://orgmode.org/manual/Export-settings.html for more information
 func (d *Document) GetOption(key string) string {
 	get := func(settings map[string]string) string {
@@ -200,7 +371,76 @@ func (d *Document) GetOption(key string) string {
 	return value
 }
 
+// resolvePath resolves p relative to the directory of the document.
+// If Configuration.FS is set, absolute paths and paths escaping the root of FS are rejected.
+func (d *Document) resolvePath(p string) (string, error) {
+	if d.FS == nil {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(d.Path), p)
+		}
+		return p, nil
+	}
+	slashPath := filepath.ToSlash(p)
+	resolved := path.Join(path.Dir(filepath.ToSlash(d.Path)), slashPath)
+	if path.IsAbs(slashPath) || filepath.IsAbs(p) || !fs.ValidPath(resolved) {
+		return "", &fs.PathError{Op: "open", Path: p, Err: ErrPathOutsideRoot}
+	}
+	return resolved, nil
+}
+
+func (d *Document) readFile(path string) ([]byte, error) {
+	return d.readFileContext(d.Context(), path)
+}
+
+func (d *Document) readFileContext(ctx context.Context, path string) ([]byte, error) {
+	if d.ReadFileContext != nil {
+		return d.ReadFileContext(ctx, path)
+	} else if d.FS != nil {
+		return fs.ReadFile(d.FS, path)
+	}
+	return d.ReadFile(path)
+}
+
+// parseOne parses the element starting at token i. If it fails to parse, all changes to the parser state
+// made while trying are reverted (see journal) and the element is parsed as plain text instead.
 func (d *Document) parseOne(i int, stop stopFn) (consumed int, node Node) {
+	defer d.nest()()
+	t, baseLvl, headlineLvl, lastLineNumber := d.tokens[i], d.baseLvl, d.headlineLvl, d.lastLineNumber
+	captions, citedKeys, diagnostics, undo := len(d.Captions), len(d.citedKeys), len(d.Diagnostics), len(d.undo)
+	consumed, node, err := d.parseToken(i, stop)
+	if err != nil {
+		for j := len(d.undo) - 1; j >= undo; j-- {
+			d.undo[j]()
+		}
+		d.tokens[i], d.baseLvl, d.headlineLvl, d.lastLineNumber = t, baseLvl, headlineLvl, lastLineNumber
+		d.Captions, d.citedKeys, d.Diagnostics, d.undo = d.Captions[:captions], d.citedKeys[:citedKeys], d.Diagnostics[:diagnostics], d.undo[:undo]
+		d.addDiagnostic(d.line(i), fmt.Sprintf("could not parse %s: %v", t.kind, err))
+		if t.kind == "text" {
+			return 1, Paragraph{[]Node{Text{t.matches[0], true}}}
+		}
+	} else if consumed != 0 {
+		if undo == 0 {
+			d.undo = d.undo[:0]
+		}
+		return consumed, node
+	} else {
+		d.Log.Printf("Could not parse token %#v: Falling back to treating it as plain text.", d.tokens[i])
+	}
+	m := plainTextRegexp.FindStringSubmatch(d.tokens[i].matches[0])
+	d.tokens[i] = token{"text", len(m[1]), m[2], m}
+	return d.parseOne(i, stop)
+}
+
+// parseToken parses the token at index i. Panics are returned as errors to allow falling back to plain text.
+func (d *Document) parseToken(i int, stop stopFn) (consumed int, node Node, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = recoverable(recovered)
+		}
+	}()
+	if consumed, node = d.parseExtensionBlock(i, stop); consumed != 0 {
+		return consumed, node, nil
+	}
 	switch d.tokens[i].kind {
 	case "unorderedList", "orderedList":
 		consumed, node = d.parseList(i, stop)
@@ -228,33 +468,117 @@ func (d *Document) parseOne(i int, stop stopFn) (consumed int, node Node) {
 		consumed, node = d.parseFootnoteDefinition(i, stop)
 	}
 
-	if consumed != 0 {
-		return consumed, node
-	}
-	d.Log.Printf("Could not parse token %#v: Falling back to treating it as plain text.", d.tokens[i])
-	m := plainTextRegexp.FindStringSubmatch(d.tokens[i].matches[0])
-	d.tokens[i] = token{"text", len(m[1]), m[2], m}
-	return d.parseOne(i, stop)
+	return consumed, node, nil
 }
 
 func (d *Document) parseMany(i int, stop stopFn) (int, []Node) {
-	start, nodes := i, []Node{}
+	start, nodes, lines := i, []Node{}, []int{}
 	for i < len(d.tokens) && !stop(d, i) {
+		d.checkContext()
 		consumed, node := d.parseOne(i, stop)
+		lines = append(lines, d.line(i))
 		i += consumed
 		nodes = append(nodes, node)
 	}
+	// nodes are identified by their address - i.e. the lines are lost for nodes that are copied into other slices
+	for j := range nodes {
+		d.nodeLines[&nodes[j]] = lines[j]
+	}
 	return i - start, nodes
 }
 
+// line returns the 1-based line of token i in the input. Nodes of #+INCLUDEd files are attributed to
+// the line of the #+INCLUDE in the document - their line in the included file would be misleading.
+func (d *Document) line(i int) int {
+	if len(d.includeStack) > 1 {
+		return d.includeLine
+	}
+	return i + 1
+}
+
+func (d *Document) addDiagnostic(line int, message string) {
+	if d.write != nil {
+		d.write.diagnostics = append(d.write.diagnostics, Diagnostic{line, message})
+	} else {
+		d.Diagnostics = append(d.Diagnostics, Diagnostic{line, message})
+	}
+	if line != 0 {
+		d.Log.Printf("Line %d: %s: Falling back to treating it as plain text.", line, message)
+	} else {
+		d.Log.Printf("%s: Falling back to treating it as plain text.", message)
+	}
+}
+
+// addFileDiagnostic records that the file referenced by the keyword k at line could not be loaded.
+// Logging alone is not enough - denied paths (see ErrPathOutsideRoot) would go unnoticed when parsing silently.
+func (d *Document) addFileDiagnostic(line int, k Keyword, err error) {
+	d.Diagnostics = append(d.Diagnostics, Diagnostic{line, fmt.Sprintf("could not load #+%s: %s: %v", k.Key, k.Value, err)})
+}
+
+// recoverable returns the recovered panic value as an error.
+// Exceeded limits and canceled contexts are not recoverable and are re-panicked to abort parsing / writing.
+func recoverable(recovered interface{}) error {
+	err, ok := recovered.(error)
+	if !ok {
+		return fmt.Errorf("%v", recovered)
+	}
+	if limitErr := (*LimitError)(nil); errors.As(err, &limitErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		panic(err)
+	}
+	return err
+}
+
+// nest increments the nesting depth and returns a function to decrement it again.
+func (d *Document) nest() func() {
+	d.nestingDepth++
+	if max := d.MaxNestingDepth; max > 0 && d.nestingDepth > max {
+		panic(&LimitError{"nesting depth", max})
+	}
+	return func() { d.nestingDepth-- }
+}
+
+// journal records how to undo a change of the parser state that is not restored by parseOne itself
+// (e.g. setting a map key) - in case the element making the change fails to parse.
+func (d *Document) journal(undo func()) {
+	d.undo = append(d.undo, undo)
+}
+
+// setString sets m[key] to value and journals the change.
+func (d *Document) setString(m map[string]string, key, value string) {
+	old, ok := m[key]
+	d.journal(func() {
+		if ok {
+			m[key] = old
+		} else {
+			delete(m, key)
+		}
+	})
+	m[key] = value
+}
+
+// setToken replaces the token at index i and journals the change.
+func (d *Document) setToken(i int, t token) {
+	tokens, old := d.tokens, d.tokens[i]
+	d.journal(func() { tokens[i] = old })
+	d.tokens[i] = t
+}
+
 func (d *Document) addHeadline(headline *Headline) int {
-	current := &Section{Headline: headline}
+	current, last, count := &Section{Headline: headline}, d.Outline.last, d.Outline.count
 	d.Outline.last.add(current)
 	d.Outline.count++
 	d.Outline.last = current
+	d.journal(func() {
+		current.Parent.Children = current.Parent.Children[:len(current.Parent.Children)-1]
+		d.Outline.last, d.Outline.count = last, count
+	})
 	return d.Outline.count
 }
 
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("exceeded %s limit of %d", e.Limit, e.Max)
+}
+
 func tokenize(line string) token {
 	for _, lexFn := range lexFns {
 		if token, ok := lexFn(line); ok {
@@ -0,0 +1,204 @@
+package org
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSRejectsPathsOutsideRoot(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/post.org":    {Data: []byte("#+INCLUDE: \"snippet.txt\" example text\n#+INCLUDE: \"../../secret.txt\" example text\n")},
+		"docs/snippet.txt": {Data: []byte("allowed")},
+	}
+	for _, path := range []string{"snippet.txt", "../../secret.txt", "/etc/passwd"} {
+		d := &Document{Configuration: &Configuration{FS: fsys}, Path: "docs/post.org"}
+		_, err := d.resolvePath(path)
+		if expected := path != "snippet.txt"; expected != errors.Is(err, ErrPathOutsideRoot) {
+			t.Errorf("%s: expected rejection %v, got %v", path, expected, err)
+		}
+	}
+
+	logs := &bytes.Buffer{}
+	config := New()
+	config.FS, config.Log = fsys, log.New(logs, "", 0)
+	bs, _ := fsys.ReadFile("docs/post.org")
+	out, err := config.Parse(bytes.NewReader(bs), "docs/post.org").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !strings.Contains(out, "allowed") {
+		t.Errorf("expected allowed include to be rendered: %s", out)
+	}
+	if !strings.Contains(logs.String(), ErrPathOutsideRoot.Error()) {
+		t.Errorf("expected denied include to be logged: %s", logs.String())
+	}
+
+	config = New().Silent()
+	config.FS = fsys
+	input := "#+INCLUDE: \"../../secret.txt\" example text\n#+SETUPFILE: /etc/passwd\n#+BIBLIOGRAPHY: ../../refs.bib\n#+INCLUDE: \"../../x.org\"\n"
+	d := config.Parse(strings.NewReader(input), "docs/post.org")
+	if len(d.Diagnostics) != 4 {
+		t.Fatalf("expected 4 diagnostics for the denied paths, got %#v", d.Diagnostics)
+	}
+	for i, diagnostic := range d.Diagnostics {
+		if diagnostic.Line != i+1 || !strings.Contains(diagnostic.Message, ErrPathOutsideRoot.Error()) {
+			t.Errorf("expected a diagnostic for the denied path in line %d, got %#v", i+1, diagnostic)
+		}
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	config := New().Silent()
+	config.FS = fstest.MapFS{"self.org": {Data: []byte("self\n#+INCLUDE: \"self.org\"\n")}}
+	bs, _ := config.FS.(fstest.MapFS).ReadFile("self.org")
+	out, err := config.Parse(bytes.NewReader(bs), "./self.org").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if strings.Count(out, "<p>self</p>") != 1 {
+		t.Errorf("expected file including itself not to be expanded:\n%s", out)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fsys := fstest.MapFS{"loop.org": {Data: []byte("#+SETUPFILE: loop.org\n")}}
+	deeplyNested := ""
+	for i := 0; i < 300; i++ {
+		deeplyNested += strings.Repeat(" ", i) + "- item\n"
+	}
+	tests := map[string]struct {
+		input string
+		limit string
+	}{
+		"setup file including itself": {"#+SETUPFILE: loop.org\n", "include depth"},
+		"macro expanding into itself": {"#+MACRO: loop {{{loop()}}}\n{{{loop()}}}\n", "macro depth"},
+		"deeply nested list":          {deeplyNested, "nesting depth"},
+		"oversized input":             {strings.Repeat("a", 1<<17), "input size"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := New().Silent()
+			config.FS, config.MaxInputSize = fsys, 1<<16
+			_, err := config.Parse(strings.NewReader(test.input), "loop.org").Write(NewHTMLWriter())
+			if limitErr := (*LimitError)(nil); !errors.As(err, &limitErr) || limitErr.Limit != test.limit {
+				t.Errorf("expected %s limit error, got %v", test.limit, err)
+			}
+		})
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	input := "* headline\nsome text\n#+INCLUDE: \"snippet.txt\" src text\n"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New().Silent().ParseContext(ctx, strings.NewReader(input), "").Error; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected parse to be canceled, got %v", err)
+	}
+
+	type ctxKey struct{}
+	config, readFileCtxValue := New().Silent(), interface{}(nil)
+	config.ReadFileContext = func(ctx context.Context, filename string) ([]byte, error) {
+		readFileCtxValue = ctx.Value(ctxKey{})
+		return []byte("included"), nil
+	}
+	d := config.Parse(strings.NewReader(input), "")
+	if _, err := d.WriteContext(ctx, NewHTMLWriter()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected write to be canceled, got %v", err)
+	}
+	out, err := d.WriteContext(context.WithValue(context.Background(), ctxKey{}, "value"), NewHTMLWriter())
+	if err != nil || !strings.Contains(out, "included") {
+		t.Errorf("expected write to succeed: %s %v", out, err)
+	}
+	if readFileCtxValue != "value" {
+		t.Errorf("expected ReadFileContext to receive write context, got %v", readFileCtxValue)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	config := New().Silent()
+	config.Extensions.AddLexer(func(line string) (Token, bool) {
+		if strings.HasPrefix(line, "? ") {
+			return Token{"unorderedList", 0, line[2:], []string{line, "", "?", " " + line[2:], line[2:]}}, true
+		}
+		return Token{}, false
+	})
+	d := config.Parse(strings.NewReader("before\n\n? strange bullet\n"), "")
+	d.Nodes = append(d.Nodes, List{Kind: "strange"}, Paragraph{[]Node{Text{"end", false}}})
+	expected := "<p>before</p>\n<p>? strange bullet</p>\n<p>end</p>\n"
+	for i := 0; i < 2; i++ {
+		w := NewHTMLWriter()
+		actual, err := d.Write(w)
+		if err != nil {
+			t.Fatalf("got error: %s", err)
+		}
+		if actual != expected {
+			t.Errorf("\n%s", diff(actual, expected))
+		}
+		if diagnostics := WriteDiagnostics(w); len(diagnostics) != 1 {
+			t.Errorf("expected a write diagnostic for the strange list kind: %#v", diagnostics)
+		}
+	}
+	if len(d.Diagnostics) != 1 || d.Diagnostics[0].Line != 3 {
+		t.Errorf("expected a parse diagnostic for the strange list bullet: %#v", d.Diagnostics)
+	}
+}
+
+func TestWriteErrorRecoveryWithoutOrgFallback(t *testing.T) {
+	d := New().Silent().Parse(strings.NewReader("before\n\n| a |\n\nafter\n"), "")
+	d.Nodes[2].(Table).Rows[0].Columns[0].ColumnInfo = nil
+	w := NewHTMLWriter()
+	actual, err := d.Write(w)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if strings.Contains(actual, "<table") || !strings.Contains(actual, "after") {
+		t.Errorf("expected table that can neither be written as HTML nor Org mode to be dropped:\n%s", actual)
+	}
+	if diagnostics := WriteDiagnostics(w); len(diagnostics) != 1 || diagnostics[0].Line != 3 {
+		t.Errorf("expected a write diagnostic for line 3: %#v", diagnostics)
+	}
+}
+
+func TestParseErrorRecoveryRestoresState(t *testing.T) {
+	config := New().Silent()
+	config.Extensions.AddLexer(func(line string) (Token, bool) {
+		if strings.HasPrefix(line, "? ") {
+			return Token{"unorderedList", 0, line[2:], []string{line, "", "?", " " + line[2:], line[2:]}}, true
+		}
+		return Token{}, false
+	})
+	d := config.Parse(strings.NewReader("- #+MACRO: m value\n? strange\n"), "")
+	if len(d.Macros) != 0 {
+		t.Errorf("expected macro of the list that failed to parse to be reverted: %#v", d.Macros)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	config := New().Silent()
+	d := config.Parse(strings.NewReader("{{{n}}} {{{n}}}\n"), "")
+	d.Nodes = append(d.Nodes, List{Kind: "strange"})
+	outs, diagnostics := make([]string, 4), make([][]Diagnostic, 4)
+	wg := sync.WaitGroup{}
+	for i := range outs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := NewHTMLWriter()
+			outs[i], _ = d.Write(w)
+			diagnostics[i] = WriteDiagnostics(w)
+		}(i)
+	}
+	wg.Wait()
+	for i := range outs {
+		if expected := "<p>1 2</p>\n"; outs[i] != expected || len(diagnostics[i]) != 1 {
+			t.Errorf("expected writes not to share state: %q %#v", outs[i], diagnostics[i])
+		}
+	}
+	if len(d.Diagnostics) != 0 {
+		t.Errorf("expected write diagnostics not to be added to the document: %#v", d.Diagnostics)
+	}
+}
@@ -93,5 +93,5 @@ func (d *PropertyDrawer) Get(key string) (string, bool) {
 	return "", false
 }
 
-func (n Drawer) String() string         { return orgWriter.WriteNodesAsString(n) }
-func (n PropertyDrawer) String() string { return orgWriter.WriteNodesAsString(n) }
+func (n Drawer) String() string         { return NewOrgWriter().WriteNodesAsString(n) }
+func (n PropertyDrawer) String() string { return NewOrgWriter().WriteNodesAsString(n) }
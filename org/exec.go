@@ -0,0 +1,301 @@
+package org
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Executor runs src blocks and inserts their output as #+RESULTS: into the document (see Executor.Execute).
+type Executor struct {
+	Enabled      bool                // Enabled must be set explicitly - nothing is executed otherwise.
+	Timeout      time.Duration       // Timeout for the execution of a single src block. 0 means no timeout.
+	Dir          string              // Dir is the working directory of executed src blocks (e.g. the directory of the document).
+	Interpreters map[string][]string // Interpreters maps src block languages to commands. The path of a file containing the block is appended.
+}
+
+// ErrExecutionDisabled is returned by Executor.Execute if the Executor is not Enabled.
+var ErrExecutionDisabled = errors.New("execution of src blocks is disabled")
+
+// ExecutionErrors is returned by Executor.Execute if some src blocks failed. It contains one error per failed block -
+// the results of all other blocks are inserted nonetheless.
+type ExecutionErrors []error
+
+func (errs ExecutionErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// minLinesForExampleBlock is the number of output lines from which an example block is used instead of : lines.
+// See org-babel-min-lines-for-block-output.
+const minLinesForExampleBlock = 10
+
+var variableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var executorFileExtensions = map[string]string{"sh": "sh", "bash": "sh", "python": "py", "go": "go"}
+
+// NewExecutor returns a disabled Executor for sh, bash, python and go src blocks with a timeout of 30 seconds.
+func NewExecutor() *Executor {
+	return &Executor{
+		Timeout: 30 * time.Second,
+		Interpreters: map[string][]string{
+			"sh":     {"sh"},
+			"bash":   {"bash"},
+			"python": {"python3"},
+			"go":     {"go", "run"},
+		},
+	}
+}
+
+// Execute runs all src blocks of d with an interpreter and inserts (or replaces) their results.
+// Supported header arguments: :results output|value, table|verbatim, silent|none, :cache yes and :eval no|never.
+// :results value is only different from output for python - the return value of the block is used.
+// The updated document can be written using an OrgWriter. Src blocks of #+INCLUDEd files are not executed
+// (and reported to d.Log) as their results would not be written back.
+// A failing block does not stop the execution of the others - their errors are returned as ExecutionErrors.
+func (e *Executor) Execute(ctx context.Context, d *Document) error {
+	if !e.Enabled {
+		return ErrExecutionDisabled
+	}
+	if d.Error != nil {
+		return d.Error
+	}
+	errs, i := ExecutionErrors{}, 0
+	d.Nodes = mapSrcBlocks(d.Nodes, nil, false, func(b Block, headlines []*Headline, included bool) Block {
+		if i++; isCommented(headlines) || ctx.Err() != nil {
+			return b
+		}
+		args := map[string]string{":results": "replace value", ":cache": "no", ":eval": "yes"}
+		for k, v := range b.HeaderArgs {
+			args[k] = v
+		}
+		if _, ok := e.Interpreters[args[":lang"]]; !ok || args[":eval"] == "no" || args[":eval"] == "never" {
+			return b
+		} else if included {
+			d.Log.Printf("not executing %s src block of #+INCLUDE: its results cannot be written back", args[":lang"])
+			return b
+		}
+		results := map[string]bool{}
+		for _, r := range strings.Fields(args[":results"]) {
+			results[r] = true
+		}
+		vars := map[string]string{}
+		for _, kv := range parseVariables(args[":var"]) {
+			vars[kv[0]] = kv[1]
+		}
+		hash := ""
+		if args[":cache"] == "yes" {
+			hash = executionHash(b, args, vars)
+			if r, ok := b.Result.(Result); ok && r.Hash == hash {
+				return b
+			}
+		}
+		b.HeaderArgs = args
+		output, err := e.Evaluate(ctx, b, vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("src block #%d (%s): %w", i, args[":lang"], err))
+			return b
+		}
+		if results["silent"] || results["none"] {
+			return b
+		}
+		b.Result = Result{Node: d.parseExecutionResult(output, results["table"] && !results["verbatim"]), Hash: hash}
+		return b
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// executionHash returns the :cache hash of b - it covers the body and the resolved header arguments (e.g. :var and :dir
+// inherited from properties or #+HEADER) with the variables of :var expanded.
+func executionHash(b Block, args, vars map[string]string) string {
+	lines := []string{}
+	for k, v := range args {
+		if k != ":var" && k != ":cache" {
+			lines = append(lines, k+" "+v)
+		}
+	}
+	for k, v := range vars {
+		lines = append(lines, ":var "+k+"="+v)
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(lines, "\n")+"\n"+String(b.Children))))
+}
+
+// Evaluate runs the src block b with vars assigned as variables and returns its output.
+// It can be used as Configuration.Evaluate. Variables are supported for sh, bash and python src blocks.
+func (e *Executor) Evaluate(ctx context.Context, b Block, vars map[string]string) (string, error) {
+	if !e.Enabled {
+		return "", ErrExecutionDisabled
+	}
+	args := b.HeaderArgs
+	if args == nil {
+		args = b.ParameterMap()
+	}
+	lang := args[":lang"]
+	if _, ok := e.Interpreters[lang]; !ok {
+		return "", fmt.Errorf("no interpreter for %q src blocks", lang)
+	}
+	assignments, err := assignVariables(lang, vars)
+	if err != nil {
+		return "", err
+	}
+	output := false
+	for _, r := range strings.Fields(args[":results"]) {
+		output = output || r == "output"
+	}
+	return e.run(ctx, lang, assignments+String(b.Children), !output, args[":imports"])
+}
+
+// assignVariables returns the source code to assign vars in lang.
+func assignVariables(lang string, vars map[string]string) (string, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assignments := ""
+	for _, name := range names {
+		value := vars[name]
+		if !variableNameRegexp.MatchString(name) {
+			return "", fmt.Errorf("bad variable name %q", name)
+		}
+		switch lang {
+		case "sh", "bash":
+			assignments += name + "=" + shellQuote(value) + "\n"
+		case "python":
+			if _, err := strconv.ParseFloat(value, 64); err != nil && !strings.HasPrefix(value, `"`) {
+				value = strconv.Quote(value)
+			}
+			assignments += name + " = " + value + "\n"
+		default:
+			return "", fmt.Errorf("variables are not supported for %s src blocks", lang)
+		}
+	}
+	return assignments, nil
+}
+
+// shellQuote returns value (unquoted if it is a quoted string) as a single quoted sh string.
+func shellQuote(value string) string {
+	if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+		value = unquoted
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func (e *Executor) run(ctx context.Context, lang, source string, value bool, imports string) (string, error) {
+	if e.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	switch lang {
+	case "python":
+		if value {
+			source = wrapPythonValue(source)
+		}
+	case "go":
+		source = wrapGoMain(source, imports)
+	}
+	dir, err := ioutil.TempDir("", "go-org-exec")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	extension, ok := executorFileExtensions[lang]
+	if !ok {
+		extension = lang
+	}
+	path := filepath.Join(dir, "block."+extension)
+	if err := ioutil.WriteFile(path, []byte(source), 0600); err != nil {
+		return "", err
+	}
+	interpreter := e.Interpreters[lang]
+	cmd := exec.CommandContext(ctx, interpreter[0], append(interpreter[1:len(interpreter):len(interpreter)], path)...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Dir, cmd.Stdout, cmd.Stderr = e.Dir, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("%s src block: %w: %s", lang, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// parseExecutionResult converts output into a table (one row per line, cells separated by tabs or whitespace)
+// or an example.
+func (d *Document) parseExecutionResult(output string, table bool) Node {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if table {
+		rows := make([]string, len(lines))
+		for i, line := range lines {
+			cells := strings.Split(line, "\t")
+			if len(cells) == 1 {
+				cells = strings.Fields(line)
+			}
+			rows[i] = "| " + strings.Join(cells, " | ") + " |"
+		}
+		if nodes := d.Configuration.Parse(strings.NewReader(strings.Join(rows, "\n")), d.Path).Nodes; len(nodes) == 1 {
+			return nodes[0]
+		}
+	}
+	if len(lines) >= minLinesForExampleBlock {
+		return Block{Name: "EXAMPLE", Parameters: []string{}, Children: d.parseRawInline(strings.Join(lines, "\n") + "\n")}
+	}
+	example := Example{}
+	for _, line := range lines {
+		example.Children = append(example.Children, Text{line, true})
+	}
+	return example
+}
+
+// wrapPythonValue wraps source in a function and prints its return value - one line per row for lists.
+func wrapPythonValue(source string) string {
+	lines := strings.Split(strings.TrimRight(source, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "    " + line
+	}
+	return "def main():\n" + strings.Join(lines, "\n") + `
+result = main()
+if isinstance(result, (list, tuple)):
+    for row in result:
+        print("\t".join(str(cell) for cell in row) if isinstance(row, (list, tuple)) else row)
+elif result is not None:
+    print(result)
+`
+}
+
+// wrapGoMain wraps source in package main (and func main) if it doesn't contain a package clause - like ob-go.
+// imports is the value of the :imports header argument (e.g. "fmt" "os").
+func wrapGoMain(source, imports string) string {
+	if strings.HasPrefix(strings.TrimSpace(source), "package ") {
+		return source
+	}
+	if !strings.Contains(source, "func main()") {
+		source = "func main() {\n" + source + "}\n"
+	}
+	header := "package main\n\n"
+	for _, i := range strings.Fields(imports) {
+		header += "import " + `"` + strings.Trim(i, `"`) + `"` + "\n"
+	}
+	return header + "\n" + source
+}
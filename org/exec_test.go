@@ -0,0 +1,143 @@
+package org
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExecute(t *testing.T) {
+	input := `#+BEGIN_SRC sh :results output
+echo hello
+#+END_SRC
+
+#+RESULTS:
+: stale
+
+#+BEGIN_SRC sh :results output table :cache yes
+printf 'a\tb\n1\t2\n'
+#+END_SRC
+
+#+BEGIN_SRC sh :results silent
+echo ignored
+#+END_SRC
+`
+	expected := `#+BEGIN_SRC sh :results output
+echo hello
+#+END_SRC
+
+#+RESULTS:
+: hello
+
+#+BEGIN_SRC sh :results output table :cache yes
+printf 'a\tb\n1\t2\n'
+#+END_SRC
+
+#+RESULTS[9bd11e99d795bb075e4c2afb22e60631ad9bbbde]:
+| a | b |
+| 1 | 2 |
+
+#+BEGIN_SRC sh :results silent
+echo ignored
+#+END_SRC
+`
+	d := New().Silent().Parse(strings.NewReader(input), "")
+	if err := NewExecutor().Execute(context.Background(), d); err != ErrExecutionDisabled {
+		t.Fatalf("expected execution to be disabled by default, got %v", err)
+	}
+	executor := NewExecutor()
+	executor.Enabled = true
+	if err := executor.Execute(context.Background(), d); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	actual, err := d.Write(NewOrgWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+
+	executor.Interpreters["sh"] = []string{"false"}
+	cached := actual[strings.Index(actual, "#+BEGIN_SRC sh :results output table"):strings.Index(actual, "#+BEGIN_SRC sh :results silent")]
+	d = New().Silent().Parse(strings.NewReader(cached), "")
+	if err := executor.Execute(context.Background(), d); err != nil {
+		t.Errorf("expected cached block not to be executed, got %s", err)
+	}
+}
+
+func TestExecuteFailingSrcBlock(t *testing.T) {
+	input := `#+BEGIN_SRC sh :results output
+exit 1
+#+END_SRC
+
+#+BEGIN_SRC sh :results output
+echo hello
+#+END_SRC
+`
+	d := New().Silent().Parse(strings.NewReader(input), "")
+	executor := NewExecutor()
+	executor.Enabled = true
+	err := executor.Execute(context.Background(), d)
+	if errs, ok := err.(ExecutionErrors); !ok || len(errs) != 1 || !strings.Contains(err.Error(), "src block #1") {
+		t.Fatalf("expected one ExecutionError for the first block, got %v", err)
+	}
+	if actual, _ := d.Write(NewOrgWriter()); !strings.HasSuffix(actual, "#+RESULTS:\n: hello\n") {
+		t.Errorf("expected result of second block despite failing first block, got\n%s", actual)
+	}
+}
+
+func TestExecuteCacheHash(t *testing.T) {
+	block := "#+BEGIN_SRC sh :results output :cache yes\necho $x\n#+END_SRC\n"
+	hash := func(input string) string {
+		d := New().Silent().Parse(strings.NewReader(input), "")
+		executor := NewExecutor()
+		executor.Enabled = true
+		if err := executor.Execute(context.Background(), d); err != nil {
+			t.Fatalf("got error: %s", err)
+		}
+		actual, _ := d.Write(NewOrgWriter())
+		start := strings.Index(actual, "#+RESULTS[")
+		return actual[start : start+len("#+RESULTS[")+40]
+	}
+	a := hash("#+PROPERTY: header-args :var x=1\n\n" + block)
+	b := hash("#+PROPERTY: header-args :var x=2\n\n" + block)
+	c := hash("#+HEADER: :dir /tmp\n" + block)
+	if a == b || a == c || b == c {
+		t.Errorf("expected hash to cover inherited :var and :dir, got %s, %s and %s", a, b, c)
+	}
+}
+
+func TestExecuteIncludedSrcBlocks(t *testing.T) {
+	logs := &bytes.Buffer{}
+	config := New()
+	config.Log = log.New(logs, "", 0)
+	config.FS = fstest.MapFS{"included.org": {Data: []byte("#+BEGIN_SRC sh :results output\necho included\n#+END_SRC\n")}}
+	d := config.Parse(strings.NewReader("#+INCLUDE: \"included.org\"\n"), "./main.org")
+	executor := NewExecutor()
+	executor.Enabled = true
+	executor.Interpreters["sh"] = []string{"false"}
+	if err := executor.Execute(context.Background(), d); err != nil {
+		t.Fatalf("expected src block of #+INCLUDE not to be executed, got %s", err)
+	} else if !strings.Contains(logs.String(), "not executing sh src block of #+INCLUDE") {
+		t.Errorf("expected skipped src block to be logged, got %q", logs.String())
+	}
+}
+
+func TestAssignVariables(t *testing.T) {
+	vars := map[string]string{"quoted": `"a b; echo c"`, "unquoted": "it's", "number": "1"}
+	actual, err := assignVariables("sh", vars)
+	if expected := "number='1'\nquoted='a b; echo c'\nunquoted='it'\\''s'\n"; err != nil || actual != expected {
+		t.Errorf("got %q (%v), expected %q", actual, err, expected)
+	}
+	actual, err = assignVariables("python", vars)
+	if expected := "number = 1\nquoted = \"a b; echo c\"\nunquoted = \"it's\"\n"; err != nil || actual != expected {
+		t.Errorf("got %q (%v), expected %q", actual, err, expected)
+	}
+	if _, err := assignVariables("sh", map[string]string{"a;b": "c"}); err == nil {
+		t.Errorf("expected error for bad variable name")
+	}
+}
@@ -0,0 +1,114 @@
+package org
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Token is a lexed line of the input. Custom lexers return Tokens and custom block parsers read them via Document.Token().
+type Token struct {
+	Kind    string   // Kind is used to look up the BlockParseFn that is responsible for the token.
+	Lvl     int      // Lvl is the indentation of the line.
+	Content string   // Content is the relevant part of the line (e.g. the name of a block or the text of a list item).
+	Matches []string // Matches[0] must contain the complete line - it is used to fall back to plain text.
+}
+
+// LexFn lexes a single line. Custom LexFns are tried in order before the builtin ones.
+type LexFn = func(line string) (t Token, ok bool)
+
+// BlockParseFn parses the token at index i (and any following tokens) into a node and returns the number of consumed tokens.
+// Returning 0 passes the token on to the next BlockParseFn and finally the builtin parser for the token kind.
+type BlockParseFn = func(d *Document, i int, stop func(*Document, int) bool) (consumed int, node Node)
+
+// InlineParseFn parses inline markup starting at input[start] and returns the number of consumed bytes.
+// Returning 0 passes the input on to the next InlineParseFn and finally the builtin parser for the trigger byte.
+type InlineParseFn = func(d *Document, input string, start int) (consumed int, node Node)
+
+// NodeWriteFn writes a custom node (i.e. a node returned from a custom parser) using w.
+type NodeWriteFn = func(w Writer, n Node)
+
+// Extensions contains custom parsers that are tried before the builtin parsers.
+type Extensions struct {
+	Lexers        []LexFn                   // Lexers are tried in order for each line of the input.
+	BlockParsers  map[string][]BlockParseFn // BlockParsers are keyed by token kind (e.g. "beginBlock", "keyword" or a custom kind).
+	InlineParsers map[byte][]InlineParseFn  // InlineParsers are keyed by the byte that triggers them (e.g. '@' or '#').
+}
+
+// AddLexer registers a custom lexer. It is tried before all builtin lexers.
+func (e *Extensions) AddLexer(f LexFn) { e.Lexers = append(e.Lexers, f) }
+
+// AddBlockParser registers a custom block parser for tokens of the given kind.
+func (e *Extensions) AddBlockParser(kind string, f BlockParseFn) {
+	if e.BlockParsers == nil {
+		e.BlockParsers = map[string][]BlockParseFn{}
+	}
+	e.BlockParsers[kind] = append(e.BlockParsers[kind], f)
+}
+
+// AddInlineParser registers a custom inline parser for the given trigger byte.
+func (e *Extensions) AddInlineParser(trigger byte, f InlineParseFn) {
+	if e.InlineParsers == nil {
+		e.InlineParsers = map[byte][]InlineParseFn{}
+	}
+	e.InlineParsers[trigger] = append(e.InlineParsers[trigger], f)
+}
+
+// Token returns the token at index i.
+func (d *Document) Token(i int) Token {
+	t := d.tokens[i]
+	return Token{t.kind, t.lvl, t.content, t.matches}
+}
+
+// ParseOne parses the token at index i (using custom and builtin parsers) and returns the number of consumed tokens.
+func (d *Document) ParseOne(i int, stop func(*Document, int) bool) (int, Node) {
+	return d.parseOne(i, stop)
+}
+
+// ParseMany parses tokens starting at index i until stop returns true.
+func (d *Document) ParseMany(i int, stop func(*Document, int) bool) (int, []Node) {
+	return d.parseMany(i, stop)
+}
+
+// ParseInline parses inline markup (emphasis, links, ...) of the input.
+func (d *Document) ParseInline(input string) []Node { return d.parseInline(input) }
+
+// ParseRawInline splits the input into raw text and line break nodes.
+func (d *Document) ParseRawInline(input string) []Node { return d.parseRawInline(input) }
+
+func (d *Document) tokenizeLine(line string) token {
+	for _, lexFn := range d.Extensions.Lexers {
+		if t, ok := lexFn(line); ok {
+			if len(t.Matches) == 0 {
+				t.Matches = []string{line}
+			}
+			return token{t.Kind, t.Lvl, t.Content, t.Matches}
+		}
+	}
+	return tokenize(line)
+}
+
+func (d *Document) parseExtensionBlock(i int, stop stopFn) (int, Node) {
+	for _, parse := range d.Extensions.BlockParsers[d.tokens[i].kind] {
+		if consumed, node := parse(d, i, stop); consumed != 0 {
+			return consumed, node
+		}
+	}
+	return 0, nil
+}
+
+func (d *Document) parseExtensionInline(input string, start int) (int, Node) {
+	for _, parse := range d.Extensions.InlineParsers[input[start]] {
+		if consumed, node := parse(d, input, start); consumed != 0 {
+			return consumed, node
+		}
+	}
+	return 0, nil
+}
+
+func writeCustomNode(w Writer, writeFns map[reflect.Type]NodeWriteFn, n Node) {
+	if write, ok := writeFns[reflect.TypeOf(n)]; ok {
+		write(w, n)
+		return
+	}
+	panic(fmt.Sprintf("bad node %T %#v", n, n))
+}
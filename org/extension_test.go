@@ -0,0 +1,123 @@
+package org
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type Callout struct{ Children []Node }
+type Mention struct{ Name string }
+
+func (n Callout) String() string { return "#+BEGIN_CALLOUT\n" + String(n.Children) + "#+END_CALLOUT\n" }
+func (n Mention) String() string { return "@" + n.Name }
+
+var mentionRegexp = regexp.MustCompile(`^@(\w+)`)
+var ticketRegexp = regexp.MustCompile(`^#ticket-(\d+)`)
+
+func newExtendedConfiguration() *Configuration {
+	c := New().Silent()
+	c.Extensions.AddBlockParser("beginBlock", func(d *Document, i int, stop func(*Document, int) bool) (int, Node) {
+		if d.Token(i).Content != "CALLOUT" {
+			return 0, nil
+		}
+		consumed, nodes := d.ParseMany(i+1, func(d *Document, i int) bool {
+			return stop(d, i) || d.Token(i).Kind == "endBlock"
+		})
+		return consumed + 2, Callout{nodes}
+	})
+	c.Extensions.AddInlineParser('@', func(d *Document, input string, start int) (int, Node) {
+		if m := mentionRegexp.FindStringSubmatch(input[start:]); m != nil {
+			return len(m[0]), Mention{m[1]}
+		}
+		return 0, nil
+	})
+	c.Extensions.AddInlineParser('#', func(d *Document, input string, start int) (int, Node) {
+		if m := ticketRegexp.FindStringSubmatch(input[start:]); m != nil {
+			return len(m[0]), RegularLink{"https", []Node{Text{m[0], false}}, "https://tickets.example.com/" + m[1], false}
+		}
+		return 0, nil
+	})
+	return c
+}
+
+func TestExtensions(t *testing.T) {
+	input := "#+BEGIN_CALLOUT\nping @alice about #ticket-123\n#+END_CALLOUT\n@@html:<b>export</b>@@\n"
+	htmlWriter := NewHTMLWriter()
+	htmlWriter.NodeWriters = map[reflect.Type]NodeWriteFn{
+		reflect.TypeOf(Callout{}): func(w Writer, n Node) {
+			w.(*HTMLWriter).WriteString(`<aside class="callout">` + "\n")
+			WriteNodes(w, n.(Callout).Children...)
+			w.(*HTMLWriter).WriteString("</aside>\n")
+		},
+		reflect.TypeOf(Mention{}): func(w Writer, n Node) {
+			w.(*HTMLWriter).WriteString(`<span class="mention">@` + n.(Mention).Name + `</span>`)
+		},
+	}
+	expected := `<aside class="callout">
+<p>ping <span class="mention">@alice</span> about <a href="https://tickets.example.com/123">#ticket-123</a></p>
+</aside>
+<p><b>export</b></p>
+`
+	actual, err := newExtendedConfiguration().Parse(strings.NewReader(input), "").Write(htmlWriter)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
+
+func TestExtensionsWithoutNodeWriter(t *testing.T) {
+	input := "hello @bob\n"
+	d := newExtendedConfiguration().Parse(strings.NewReader(input), "")
+	w := NewHTMLWriter()
+	actual, err := d.Write(w)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if expected := "<p>hello @bob</p>\n"; actual != expected {
+		t.Errorf("expected custom node without node writer to fall back to plain text:\n%s", diff(actual, expected))
+	}
+	if diagnostics := WriteDiagnostics(w); len(diagnostics) != 1 {
+		t.Errorf("expected a diagnostic for the custom node: %#v", diagnostics)
+	}
+}
+
+func TestExtensionsWithFailingNodeWriter(t *testing.T) {
+	input := "before\n\n#+BEGIN_CALLOUT\ntext\n#+END_CALLOUT\n"
+	w := NewHTMLWriter()
+	w.NodeWriters = map[reflect.Type]NodeWriteFn{
+		reflect.TypeOf(Callout{}): func(w Writer, n Node) {
+			w.(*HTMLWriter).WriteString(`<aside class="callout">` + "\n")
+			WriteNodes(w, n.(Callout).Children...)
+			panic("unclosed aside")
+		},
+	}
+	actual, err := newExtendedConfiguration().Parse(strings.NewReader(input), "").Write(w)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if expected := "<p>before</p>\n#+BEGIN_CALLOUT\ntext\n#+END_CALLOUT\n"; actual != expected {
+		t.Errorf("expected partial output of the failing node to be discarded:\n%s", diff(actual, expected))
+	}
+	if diagnostics := WriteDiagnostics(w); len(diagnostics) != 1 || diagnostics[0].Line != 3 {
+		t.Errorf("expected a diagnostic for line 3: %#v", diagnostics)
+	}
+}
+
+func TestExtensionsWithFailingNodeWriterInInclude(t *testing.T) {
+	config := newExtendedConfiguration()
+	config.FS = fstest.MapFS{"included.org": {Data: []byte("a\n\nb\n\n#+BEGIN_CALLOUT\ntext\n#+END_CALLOUT\n")}}
+	w := NewHTMLWriter()
+	w.NodeWriters = map[reflect.Type]NodeWriteFn{reflect.TypeOf(Callout{}): func(w Writer, n Node) { panic("failing") }}
+	d := config.Parse(strings.NewReader("before\n\n#+INCLUDE: \"included.org\"\n"), "./main.org")
+	if _, err := d.Write(w); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if diagnostics := WriteDiagnostics(w); len(diagnostics) != 1 || diagnostics[0].Line != 3 {
+		t.Errorf("expected a diagnostic for the line of the #+INCLUDE: %#v", diagnostics)
+	}
+}
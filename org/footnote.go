@@ -21,7 +21,7 @@ func lexFootnoteDefinition(line string) (token, bool) {
 
 func (d *Document) parseFootnoteDefinition(i int, parentStop stopFn) (int, Node) {
 	start, name := i, d.tokens[i].content
-	d.tokens[i] = tokenize(d.tokens[i].matches[2])
+	d.setToken(i, d.tokenizeLine(d.tokens[i].matches[2]))
 	stop := func(d *Document, i int) bool {
 		return parentStop(d, i) ||
 			(isSecondBlankLine(d, i) && i > start+1) ||
@@ -29,7 +29,44 @@ func (d *Document) parseFootnoteDefinition(i int, parentStop stopFn) (int, Node)
 	}
 	consumed, nodes := d.parseMany(i, stop)
 	definition := FootnoteDefinition{name, nodes, false}
+	old, ok := d.footnotes[name]
+	d.journal(func() {
+		if ok {
+			d.footnotes[name] = old
+		} else {
+			delete(d.footnotes, name)
+		}
+	})
+	d.footnotes[name] = &definition
 	return consumed, definition
 }
 
-func (n FootnoteDefinition) String() string { return orgWriter.WriteNodesAsString(n) }
+// footnoteNumbers numbers footnotes in the order of their first reference for writers that collect the
+// definitions to write them at the end of the document (see TextWriter and ManWriter).
+type footnoteNumbers struct {
+	footnotes     []*FootnoteDefinition
+	footnoteNames map[string]int
+}
+
+// number returns the number of the footnote referenced by l - adding its definition on the first reference.
+// Anonymous footnotes (inline definitions without a name) get a new number for every reference.
+func (f *footnoteNumbers) number(d *Document, l FootnoteLink) int {
+	i, ok := f.footnoteNames[l.Name]
+	if !ok || l.Name == "" {
+		definition := l.Definition
+		if definition == nil {
+			definition = d.footnotes[l.Name]
+		}
+		i = len(f.footnotes)
+		f.footnotes = append(f.footnotes, definition)
+		if l.Name != "" {
+			if f.footnoteNames == nil {
+				f.footnoteNames = map[string]int{}
+			}
+			f.footnoteNames[l.Name] = i
+		}
+	}
+	return i + 1
+}
+
+func (n FootnoteDefinition) String() string { return NewOrgWriter().WriteNodesAsString(n) }
@@ -3,6 +3,7 @@ package org
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -28,11 +29,23 @@ type Headline struct {
 	Title      []Node
 	Tags       []string
 	Children   []Node
+	id         string
 }
 
+// HeadlineIDStrategy determines the ids of headlines without a CUSTOM_ID or ID property (see Headline.ID).
+type HeadlineIDStrategy int
+
+const (
+	IndexHeadlineIDs HeadlineIDStrategy = iota // headline-N - N is the position of the headline in the document.
+	SlugHeadlineIDs                            // Derived from the title (e.g. some-title). Duplicates get a -N suffix.
+)
+
 var headlineRegexp = regexp.MustCompile(`^([*]+)\s+(.*)`)
 var tagRegexp = regexp.MustCompile(`(.*?)\s+(:[A-Za-z0-9_@#%:]+:\s*$)`)
 
+// writerIDRegexp matches the ids generated by the writers (footnotes, captioned elements, slides) - headline ids must not collide with them.
+var writerIDRegexp = regexp.MustCompile(`^(table-of-contents|footnotes|title-slide|(footnote|footnote-reference|figure|table|listing)-\d+)$`)
+
 func lexHeadline(line string) (token, bool) {
 	if m := headlineRegexp.FindStringSubmatch(line); m != nil {
 		return token{"headline", 0, m[2], m}, true
@@ -46,47 +59,190 @@ func (d *Document) parseHeadline(i int, parentStop stopFn) (int, Node) {
 
 	headline.Index = d.addHeadline(&headline)
 
-	text := t.content
+	status, priority, text, tags := d.splitHeadline(t.content)
+	headline.Status, headline.Priority, headline.Tags = status, priority, tags
+	headline.Title = d.parseInline(text)
+
+	stop := func(d *Document, i int) bool {
+		return parentStop(d, i) || d.tokens[i].kind == "headline" && len(d.tokens[i].matches[1]) <= headline.Lvl
+	}
+	parentLvl := d.headlineLvl
+	d.headlineLvl = headline.Lvl
+	consumed, nodes := d.parseMany(i+1, stop)
+	d.headlineLvl = parentLvl
+	if len(nodes) > 0 {
+		if d, ok := nodes[0].(PropertyDrawer); ok {
+			headline.Properties = &d
+			nodes = nodes[1:]
+		}
+	}
+	headline.Children = nodes
+	return consumed + 1, headline
+}
+
+// splitHeadline splits the text of a headline (i.e. everything after the stars) into status, priority, title and tags.
+func (d *Document) splitHeadline(text string) (status, priority, title string, tags []string) {
 	todoKeywords := strings.FieldsFunc(d.Get("TODO"), func(r rune) bool { return unicode.IsSpace(r) || r == '|' })
 	for _, k := range todoKeywords {
 		if strings.HasPrefix(text, k) && len(text) > len(k) && unicode.IsSpace(rune(text[len(k)])) {
-			headline.Status = k
+			status = k
 			text = text[len(k)+1:]
 			break
 		}
 	}
 
 	if len(text) >= 4 && text[0:2] == "[#" && strings.Contains("ABC", text[2:3]) && text[3] == ']' {
-		headline.Priority = text[2:3]
+		priority = text[2:3]
 		text = strings.TrimSpace(text[4:])
 	}
 
 	if m := tagRegexp.FindStringSubmatch(text); m != nil {
 		text = m[1]
-		headline.Tags = strings.FieldsFunc(m[2], func(r rune) bool { return r == ':' })
+		tags = strings.FieldsFunc(m[2], func(r rune) bool { return r == ':' })
 	}
+	return status, priority, text, tags
+}
 
-	headline.Title = d.parseInline(text)
+// ID returns the CUSTOM_ID or ID property of the headline. Otherwise an id is generated according to
+// Configuration.HeadlineIDs.
+func (h Headline) ID() string {
+	for _, key := range []string{"CUSTOM_ID", "ID"} {
+		if id, ok := h.Properties.Get(key); ok {
+			return id
+		}
+	}
+	if h.id != "" {
+		return h.id
+	}
+	return fmt.Sprintf("headline-%d", h.Index)
+}
 
-	stop := func(d *Document, i int) bool {
-		return parentStop(d, i) || d.tokens[i].kind == "headline" && len(d.tokens[i].matches[1]) <= headline.Lvl
+// assignHeadlineIDs sets the ids of headlines without a CUSTOM_ID or ID property according to Configuration.HeadlineIDs.
+// It is called once the whole document has been parsed - generated ids must not collide with the explicit ids
+// of later headlines either.
+func (d *Document) assignHeadlineIDs() {
+	if d.HeadlineIDs != SlugHeadlineIDs {
+		return
 	}
-	consumed, nodes := d.parseMany(i+1, stop)
-	if len(nodes) > 0 {
-		if d, ok := nodes[0].(PropertyDrawer); ok {
-			headline.Properties = &d
-			nodes = nodes[1:]
+	headlines, usedIDs, ids := []*Headline{}, map[string]bool{}, map[int]string{}
+	d.findHeadline(func(h *Headline) bool {
+		explicit := false
+		for _, key := range []string{"CUSTOM_ID", "ID"} {
+			if id, ok := h.Properties.Get(key); ok {
+				usedIDs[id], explicit = true, true
+			}
+		}
+		if !explicit {
+			headlines = append(headlines, h)
 		}
+		return false
+	})
+	for _, h := range headlines {
+		h.id = uniqueHeadlineID(slugify(plainText(h.Title...)), usedIDs)
+		ids[h.Index] = h.id
 	}
-	headline.Children = nodes
-	return consumed + 1, headline
+	setHeadlineIDs(d.Nodes, ids)
 }
 
-func (h Headline) ID() string {
-	if customID, ok := h.Properties.Get("CUSTOM_ID"); ok {
-		return customID
+// setHeadlineIDs sets the ids of the headlines in nodes by their Index.
+func setHeadlineIDs(nodes []Node, ids map[int]string) {
+	for i, n := range nodes {
+		switch n := n.(type) {
+		case Headline:
+			n.id = ids[n.Index]
+			setHeadlineIDs(n.Children, ids)
+			nodes[i] = n
+		case Include:
+			setHeadlineIDs(n.Children, ids)
+		}
 	}
-	return fmt.Sprintf("headline-%d", h.Index)
+}
+
+// uniqueHeadlineID returns id - or, if id is already in usedIDs or generated by a writer, id-N - and adds it to usedIDs.
+func uniqueHeadlineID(id string, usedIDs map[string]bool) string {
+	unique := id
+	for i := 1; usedIDs[unique] || writerIDRegexp.MatchString(unique); i++ {
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+	usedIDs[unique] = true
+	return unique
+}
+
+// slugify converts s into a lowercase id consisting of letters, digits and dashes.
+func slugify(s string) string {
+	slug, dash := strings.Builder{}, false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() != 0 {
+				slug.WriteRune('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if slug.Len() == 0 {
+		return "headline"
+	}
+	return slug.String()
+}
+
+// plainText returns the text content of nodes without markup - e.g. the description of links.
+func plainText(nodes ...Node) string {
+	text := ""
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case Text:
+			text += n.Content
+		case Emphasis:
+			text += plainText(n.Content...)
+		case LatexFragment:
+			text += plainText(n.Content...)
+		case RegularLink:
+			if n.Description != nil {
+				text += plainText(n.Description...)
+			} else {
+				text += n.URL
+			}
+		case InlineBlock:
+			text += plainText(n.Children...)
+		case Macro, FootnoteLink, StatisticToken, Timestamp:
+		default:
+			text += n.String()
+		}
+	}
+	return text
+}
+
+// findHeadline returns the first headline of the document matching f - or nil.
+func (d *Document) findHeadline(f func(*Headline) bool) *Headline {
+	var find func([]*Section) *Headline
+	find = func(sections []*Section) *Headline {
+		for _, section := range sections {
+			if f(section.Headline) {
+				return section.Headline
+			} else if h := find(section.Children); h != nil {
+				return h
+			}
+		}
+		return nil
+	}
+	return find(d.Outline.Children)
+}
+
+// linkedHeadline returns the headline targeted by an internal link to *TITLE, #CUSTOM_ID or id:ID - or nil.
+func (d *Document) linkedHeadline(l RegularLink) *Headline {
+	switch {
+	case l.Protocol == "" && strings.HasPrefix(l.URL, "*"):
+		title := strings.Join(strings.Fields(l.URL[1:]), " ")
+		return d.findHeadline(func(h *Headline) bool { return strings.Join(strings.Fields(String(h.Title)), " ") == title })
+	case l.Protocol == "" && strings.HasPrefix(l.URL, "#"):
+		return d.findHeadline(func(h *Headline) bool { id, _ := h.Properties.Get("CUSTOM_ID"); return id == l.URL[1:] })
+	case l.Protocol == "id":
+		return d.findHeadline(func(h *Headline) bool { id, _ := h.Properties.Get("ID"); return id == l.URL[len("id:"):] })
+	}
+	return nil
 }
 
 func (h Headline) IsExcluded(d *Document) bool {
@@ -100,6 +256,59 @@ func (h Headline) IsExcluded(d *Document) bool {
 	return false
 }
 
+// IsUnnumbered returns true if the UNNUMBERED property of the headline is set (e.g. to t or notoc).
+// Unnumbered headlines and their children don't get a section number.
+func (h Headline) IsUnnumbered() bool {
+	value, ok := h.Properties.Get("UNNUMBERED")
+	return ok && value != "nil"
+}
+
+// sectionNumbers returns the section numbers (e.g. 3.2.1) of the headlines of d keyed by Headline.Index.
+// Headlines are numbered according to the num and H export options.
+func (d *Document) sectionNumbers() map[int]string {
+	numbers, maxLvl := map[int]string{}, 0
+	switch num := d.GetOption("num"); num {
+	case "nil":
+		return numbers
+	case "t":
+	default:
+		if lvl, err := strconv.Atoi(num); err == nil {
+			maxLvl = lvl
+		}
+	}
+	if lvl := d.headlineLvlLimit(); lvl != 0 && (maxLvl == 0 || lvl < maxLvl) {
+		maxLvl = lvl
+	}
+	var number func([]*Section, string)
+	number = func(sections []*Section, prefix string) {
+		i := 0
+		for _, section := range sections {
+			h := section.Headline
+			if (maxLvl != 0 && h.Lvl > maxLvl) || h.IsExcluded(d) || h.IsUnnumbered() {
+				continue
+			}
+			i++
+			numbers[h.Index] = prefix + strconv.Itoa(i)
+			number(section.Children, numbers[h.Index]+".")
+		}
+	}
+	number(d.Outline.Children, "")
+	return numbers
+}
+
+// headlineLvlLimit returns the value of the H export option - headlines with a deeper lvl are exported as list items.
+// 0 means no limit.
+func (d *Document) headlineLvlLimit() int {
+	lvl, _ := strconv.Atoi(d.GetOption("H"))
+	return lvl
+}
+
+// isListHeadline returns true if h is deeper than the H export option allows and thus exported as a list item.
+func (d *Document) isListHeadline(h Headline) bool {
+	maxLvl := d.headlineLvlLimit()
+	return maxLvl != 0 && h.Lvl > maxLvl
+}
+
 func (parent *Section) add(current *Section) {
 	if parent.Headline == nil || parent.Headline.Lvl < current.Headline.Lvl {
 		parent.Children = append(parent.Children, current)
@@ -109,4 +318,4 @@ func (parent *Section) add(current *Section) {
 	}
 }
 
-func (n Headline) String() string { return orgWriter.WriteNodesAsString(n) }
+func (n Headline) String() string { return NewOrgWriter().WriteNodesAsString(n) }
@@ -0,0 +1,129 @@
+package org
+
+import (
+	"strings"
+
+	h "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// safeHTMLElements are the elements kept by sanitizeHTML. Other elements are replaced by their children -
+// except for unsafeHTMLElements, which are removed including their children.
+var safeHTMLElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Article: true, atom.Aside: true, atom.B: true, atom.Blockquote: true, atom.Br: true, atom.Caption: true,
+	atom.Cite: true, atom.Code: true, atom.Dd: true, atom.Del: true, atom.Details: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Em: true, atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.H1: true,
+	atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true, atom.I: true,
+	atom.Img: true, atom.Ins: true, atom.Kbd: true, atom.Li: true, atom.Mark: true, atom.Nav: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Q: true, atom.S: true, atom.Samp: true, atom.Section: true, atom.Small: true, atom.Span: true,
+	atom.Strong: true, atom.Sub: true, atom.Summary: true, atom.Sup: true, atom.Table: true, atom.Tbody: true,
+	atom.Td: true, atom.Tfoot: true, atom.Th: true, atom.Thead: true, atom.Tr: true, atom.U: true, atom.Ul: true,
+	atom.Var: true, atom.Video: true,
+}
+
+var unsafeHTMLElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Frame: true, atom.Frameset: true,
+	atom.Object: true, atom.Embed: true, atom.Applet: true, atom.Noscript: true, atom.Template: true,
+	atom.Form: true, atom.Input: true, atom.Button: true, atom.Textarea: true, atom.Select: true,
+	atom.Link: true, atom.Meta: true, atom.Base: true, atom.Svg: true, atom.Title: true,
+}
+
+// safeMathMLElements are the MathML elements kept by sanitizeHTML (see HTMLWriter.MathML) - by name, as most of them have no atom.
+var safeMathMLElements = map[string]bool{
+	"math": true, "semantics": true, "annotation": true, "mrow": true, "mi": true, "mn": true, "mo": true,
+	"mtext": true, "mspace": true, "msub": true, "msup": true, "msubsup": true, "munder": true, "mover": true,
+	"munderover": true, "mfrac": true, "msqrt": true, "mroot": true, "mtable": true, "mtr": true, "mtd": true,
+}
+
+// safeHTMLAttributes are the attributes kept by sanitizeHTML. Event handlers (on*) and style are never kept.
+// Raw html is escaped in Safe mode, thus ids can only be set via #+ATTR_HTML - those are prefixed (see userContentIDPrefix).
+var safeHTMLAttributes = map[string]bool{
+	"alt": true, "class": true, "colspan": true, "controls": true, "height": true, "href": true, "id": true,
+	"lang": true, "dir": true, "rowspan": true, "src": true, "start": true, "title": true, "value": true,
+	"width": true, "cite": true, "poster": true, "open": true, "reversed": true,
+	// MathML
+	"display": true, "mathvariant": true, "fence": true, "accent": true, "linethickness": true,
+	"columnalign": true, "displaystyle": true, "encoding": true,
+}
+
+var urlHTMLAttributes = map[string]bool{"href": true, "src": true, "cite": true, "poster": true, "data-background": true}
+
+var safeURLSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "ftp": true}
+
+// sanitizeHTML parses the html fragment s and removes all elements, attributes and urls that are not known to be safe.
+// The output is rendered by the html parser and thus well-formed.
+func sanitizeHTML(s string) (string, error) {
+	context := &h.Node{Type: h.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := h.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		return "", err
+	}
+	out := strings.Builder{}
+	for _, n := range nodes {
+		context.AppendChild(n)
+	}
+	sanitizeHTMLChildren(context)
+	for n := context.FirstChild; n != nil; n = n.NextSibling {
+		if err := h.Render(&out, n); err != nil {
+			return "", err
+		}
+	}
+	return out.String(), nil
+}
+
+func sanitizeHTMLChildren(parent *h.Node) {
+	for n := parent.FirstChild; n != nil; {
+		next := n.NextSibling
+		switch n.Type {
+		case h.ElementNode:
+			sanitizeHTMLChildren(n)
+			if unsafeHTMLElements[n.DataAtom] {
+				parent.RemoveChild(n)
+			} else if !safeHTMLElements[n.DataAtom] && !(n.Namespace == "math" && safeMathMLElements[n.Data]) {
+				for c := n.FirstChild; c != nil; c = n.FirstChild {
+					n.RemoveChild(c)
+					parent.InsertBefore(c, n)
+				}
+				parent.RemoveChild(n)
+			} else {
+				n.Attr = sanitizeHTMLAttributes(n.DataAtom, n.Attr)
+			}
+		case h.TextNode:
+		default:
+			parent.RemoveChild(n) // comments, doctypes, ...
+		}
+		n = next
+	}
+}
+
+func sanitizeHTMLAttributes(element atom.Atom, attributes []h.Attribute) []h.Attribute {
+	safe := attributes[:0]
+	for _, a := range attributes {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !(safeHTMLAttributes[key] || (element == atom.Section && isSlideAttribute(key))) {
+			continue
+		}
+		if urlHTMLAttributes[key] && !isSafeURL(element, a.Val) {
+			continue
+		}
+		safe = append(safe, a)
+	}
+	return safe
+}
+
+// isSafeURL returns true for relative urls and urls with a scheme in safeURLSchemes.
+// Images may also use data:image/ urls (e.g. HTMLWriter.EmbedImages).
+func isSafeURL(element atom.Atom, url string) bool {
+	// browsers ignore control characters & whitespace - e.g. java\tscript: is a javascript: url.
+	url = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, url))
+	i := strings.IndexAny(url, ":/?#")
+	if i == -1 || url[i] != ':' {
+		return true
+	}
+	return safeURLSchemes[url[:i]] || (element == atom.Img && strings.HasPrefix(url, "data:image/"))
+}
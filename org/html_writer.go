@@ -1,9 +1,14 @@
 package org
 
 import (
+	"encoding/base64"
 	"fmt"
 	"html"
+	"io/fs"
 	"log"
+	"mime"
+	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -19,19 +24,68 @@ type HTMLWriter struct {
 	ExtendingWriter     Writer
 	HighlightCodeBlock  func(source, lang string, inline bool) string
 	PrettyRelativeLinks bool
+	NodeWriters         map[reflect.Type]NodeWriteFn // NodeWriters write custom nodes (see Extensions) - keyed by the type of the node.
+	Standalone          bool                         // Standalone wraps the output in a complete html document (<html>, <head> and <body>).
+	Stylesheet          string                       // Stylesheet is inlined into the <head> of standalone documents.
+	EmbedImages         bool                         // EmbedImages inlines local images inside the directory of the document as data URIs.
+	// Safe sanitizes the output for untrusted input: Raw html (#+BEGIN_EXPORT html, @@html:...@@, #+HTML:, #+HTML_HEAD)
+	// is escaped and only whitelisted elements, attributes and url schemes are kept (see sanitizeHTML).
+	Safe          bool
+	FootnoteStyle FootnoteStyle // FootnoteStyle determines where footnote definitions are written.
+	// SpecialBlocks maps the upper case names of special blocks (e.g. WARNING for #+BEGIN_WARNING) to html elements.
+	// Blocks without an entry are written as <div class="warning"> - or as the html5 element of the same name (e.g. aside).
+	// The parameters of details blocks (#+BEGIN_DETAILS title) are written as their <summary>.
+	SpecialBlocks map[string]BlockElement
+	// MathML converts latex fragments to MathML (see latexToMathML). Fragments using unsupported commands are written as is.
+	MathML bool
+	// HighlightCodeBlockWithParams is used instead of HighlightCodeBlock if set. params contains the header arguments
+	// of the block - e.g. :hl_lines "1 3" (see HighlightedLines and HasGutter).
+	HighlightCodeBlockWithParams func(source, lang string, inline bool, params map[string]string) string
 
 	strings.Builder
 	document   *Document
 	htmlEscape bool
 	log        *log.Logger
 	footnotes  *footnotes
+	headline   *Headline
+
+	sectionNumbers  map[int]string
+	bodyStart       int
+	inHeadlineList  bool
+	inOutline       bool
+	highlightParams map[string]string // highlightParams are the params of the block highlighted by the default HighlightCodeBlock.
+}
+
+// BlockElement is the html element (and class) a special block is written as - see HTMLWriter.SpecialBlocks.
+type BlockElement struct {
+	Element string
+	Class   string
+}
+
+var html5BlockElements = map[string]bool{
+	"article": true, "aside": true, "details": true, "figure": true, "footer": true,
+	"header": true, "nav": true, "section": true,
 }
 
 type footnotes struct {
-	mapping map[string]int
-	list    []*FootnoteDefinition
+	mapping    map[string]int
+	list       []*FootnoteDefinition
+	references []int        // references contains the number of references to each footnote.
+	written    int          // written is the number of footnote definitions already written (see SectionFootnotes).
+	sidenotes  map[int]bool // sidenotes contains the footnotes written as sidenotes (see Sidenotes).
 }
 
+// FootnoteStyle determines where HTMLWriter writes footnote definitions.
+type FootnoteStyle int
+
+const (
+	EndFootnotes     FootnoteStyle = iota // At the end of the document.
+	SectionFootnotes                      // At the end of each top-level section.
+	// Next to their first reference as <span class="sidenote"> - e.g. to be styled as sidenotes or tooltips.
+	// Definitions that contain more than a single paragraph are written at the end of the document.
+	Sidenotes
+)
+
 var emphasisTags = map[string][]string{
 	"/":   []string{"<em>", "</em>"},
 	"*":   []string{"<strong>", "</strong>"},
@@ -55,34 +109,61 @@ var listItemStatuses = map[string]string{
 	"X": "checked",
 }
 
+// userContentIDPrefix is prepended to ids from #+ATTR_HTML in Safe mode. This keeps untrusted input from
+// overwriting the ids of generated elements (e.g. headlines and footnotes) and from clobbering globals of the page.
+const userContentIDPrefix = "user-content-"
+
 var cleanHeadlineTitleForHTMLAnchorRegexp = regexp.MustCompile(`</?a[^>]*>`) // nested a tags are not valid HTML
+var codeRefLinkRegexp = regexp.MustCompile(`^\(([-\w]+)\)$`)
 var tocHeadlineMaxLvlRegexp = regexp.MustCompile(`headlines\s+(\d+)`)
 
 func NewHTMLWriter() *HTMLWriter {
 	defaultConfig := New()
-	return &HTMLWriter{
+	w := &HTMLWriter{
 		document:   &Document{Configuration: defaultConfig},
 		log:        defaultConfig.Log,
 		htmlEscape: true,
-		HighlightCodeBlock: func(source, lang string, inline bool) string {
-			if inline {
-				return fmt.Sprintf("<div class=\"highlight-inline\">\n<pre>\n%s\n</pre>\n</div>", html.EscapeString(source))
-			}
-			return fmt.Sprintf("<div class=\"highlight\">\n<pre>\n%s\n</pre>\n</div>", html.EscapeString(source))
-		},
 		footnotes: &footnotes{
-			mapping: map[string]int{},
+			mapping:   map[string]int{},
+			sidenotes: map[int]bool{},
 		},
 	}
+	w.HighlightCodeBlock = w.highlightEscaped
+	return w
+}
+
+// highlightEscaped is the default HighlightCodeBlock - it escapes source and marks the lines of :hl_lines.
+func (w *HTMLWriter) highlightEscaped(source, lang string, inline bool) string {
+	if inline {
+		return fmt.Sprintf("<div class=\"highlight-inline\">\n<pre>\n%s\n</pre>\n</div>", html.EscapeString(source))
+	}
+	lines, highlighted := strings.Split(html.EscapeString(source), "\n"), map[int]bool{}
+	for _, r := range HighlightedLines(w.highlightParams) {
+		for n := r[0]; n <= r[1] && n <= len(lines); n++ {
+			if n >= 1 && !highlighted[n] {
+				lines[n-1], highlighted[n] = `<span class="hll">`+lines[n-1]+"</span>", true
+			}
+		}
+	}
+	return fmt.Sprintf("<div class=\"highlight\">\n<pre>\n%s\n</pre>\n</div>", strings.Join(lines, "\n"))
+}
+
+// highlightCodeBlock highlights source using HighlightCodeBlockWithParams - or HighlightCodeBlock.
+func (w *HTMLWriter) highlightCodeBlock(source, lang string, inline bool, params map[string]string) string {
+	if w.HighlightCodeBlockWithParams != nil {
+		return w.HighlightCodeBlockWithParams(source, lang, inline, params)
+	}
+	w.highlightParams = params
+	defer func() { w.highlightParams = nil }()
+	return w.HighlightCodeBlock(source, lang, inline)
 }
 
 func (w *HTMLWriter) WriteNodesAsString(nodes ...Node) string {
 	original := w.Builder
 	w.Builder = strings.Builder{}
+	defer func() { w.Builder = original }() // restored on panics as well - see writeNode
 	WriteNodes(w, nodes...)
-	out := w.String()
-	w.Builder = original
-	return out
+	return w.String()
 }
 
 func (w *HTMLWriter) WriterWithExtensions() Writer {
@@ -92,16 +173,24 @@ func (w *HTMLWriter) WriterWithExtensions() Writer {
 	return w
 }
 
+func (w *HTMLWriter) writtenDocument() *Document { return w.document }
+func (w *HTMLWriter) builder() *strings.Builder  { return &w.Builder }
+
 func (w *HTMLWriter) Before(d *Document) {
 	w.document = d
 	w.log = d.Log
+	if w.Standalone {
+		w.writeHead(d)
+	}
+	w.bodyStart = w.Len()
 	if title := d.Get("TITLE"); title != "" && w.document.GetOption("title") != "nil" {
-		titleDocument := d.Parse(strings.NewReader(title), d.Path)
+		titleDocument := d.parseSubDocument(title)
 		if titleDocument.Error == nil {
 			title = w.WriteNodesAsString(titleDocument.Nodes...)
 		}
 		w.WriteString(fmt.Sprintf(`<h1 class="title">%s</h1>`+"\n", title))
 	}
+	w.sectionNumbers = d.sectionNumbers()
 	if w.document.GetOption("toc") != "nil" {
 		maxLvl, _ := strconv.Atoi(w.document.GetOption("toc"))
 		w.WriteOutline(d, maxLvl)
@@ -110,13 +199,105 @@ func (w *HTMLWriter) Before(d *Document) {
 
 func (w *HTMLWriter) After(d *Document) {
 	w.WriteFootnotes(d)
+	if w.Safe {
+		w.sanitize()
+	}
+	if w.Standalone {
+		w.WriteString("</body>\n</html>\n")
+	}
+}
+
+// writeHead writes the <head> of a standalone document - linking the stylesheets before the inlined Stylesheet.
+func (w *HTMLWriter) writeHead(d *Document, stylesheets ...string) {
+	language := d.Get("LANGUAGE")
+	if language == "" {
+		language = "en"
+	}
+	w.WriteString(fmt.Sprintf("<!DOCTYPE html>\n<html lang=\"%s\">\n<head>\n", html.EscapeString(language)))
+	w.WriteString(`<meta charset="utf-8">` + "\n")
+	w.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1">` + "\n")
+	if title := d.Get("TITLE"); title != "" {
+		w.WriteString(fmt.Sprintf("<title>%s</title>\n", html.EscapeString(title)))
+	}
+	for _, meta := range [][2]string{{"author", "AUTHOR"}, {"date", "DATE"}, {"description", "DESCRIPTION"}, {"keywords", "KEYWORDS"}} {
+		if value := strings.Join(strings.Fields(d.Get(meta[1])), " "); value != "" {
+			w.WriteString(fmt.Sprintf(`<meta name="%s" content="%s">`+"\n", meta[0], html.EscapeString(value)))
+		}
+	}
+	for _, stylesheet := range stylesheets {
+		w.WriteString(fmt.Sprintf(`<link rel="stylesheet" href="%s">`+"\n", html.EscapeString(stylesheet)))
+	}
+	if w.Stylesheet != "" {
+		w.WriteString("<style>\n" + strings.TrimRight(w.Stylesheet, "\n") + "\n</style>\n")
+	}
+	for _, key := range []string{"HTML_HEAD", "HTML_HEAD_EXTRA"} {
+		if value := d.Get(key); value != "" && !w.Safe {
+			w.WriteString(value + "\n")
+		}
+	}
+	w.WriteString("</head>\n<body>\n")
+}
+
+// sanitize sanitizes everything written after the <head> (see Safe).
+func (w *HTMLWriter) sanitize() {
+	out := w.String()
+	body, err := sanitizeHTML(out[w.bodyStart:])
+	if err != nil {
+		w.log.Printf("Could not sanitize output: %s", err)
+		body = html.EscapeString(out[w.bodyStart:])
+	}
+	w.Reset()
+	w.WriteString(out[:w.bodyStart] + body)
+}
+
+// writeRaw writes raw html - or, if Safe is set, escapes it.
+func (w *HTMLWriter) writeRaw(s string) {
+	if w.Safe {
+		s = html.EscapeString(s)
+	}
+	w.WriteString(s)
+}
+
+// embedImage returns the local image at url (relative to the document) as a data URI.
+// Images outside of the directory of the document (absolute paths, ../) are not embedded - without a
+// Configuration.FS they would be read from anywhere on the file system.
+func (w *HTMLWriter) embedImage(url string) (string, bool) {
+	if strings.Contains(url, "://") || strings.HasPrefix(url, "data:") {
+		return "", false
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(url)))
+	if mimeType == "" {
+		return "", false
+	}
+	if slashURL := filepath.ToSlash(url); filepath.IsAbs(url) || !fs.ValidPath(path.Clean(slashURL)) {
+		w.log.Printf("Bad image %s: %s", url, ErrPathOutsideRoot)
+		return "", false
+	}
+	path, err := w.document.resolvePath(url)
+	if err != nil {
+		w.log.Printf("Bad image %s: %s", url, err)
+		return "", false
+	}
+	bs, err := w.document.readFile(path)
+	if err != nil {
+		w.log.Printf("Bad image %s: %s", url, err)
+		return "", false
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(bs), true
+}
+
+func (w *HTMLWriter) WriteCustomNode(n Node) {
+	writeCustomNode(w.WriterWithExtensions(), w.NodeWriters, n)
 }
 
 func (w *HTMLWriter) WriteComment(Comment)               {}
 func (w *HTMLWriter) WritePropertyDrawer(PropertyDrawer) {}
 
 func (w *HTMLWriter) WriteBlock(b Block) {
-	content, params := w.blockContent(b.Name, b.Children), b.ParameterMap()
+	content, params := w.blockContent(b.Name, b.Children), b.HeaderArgs
+	if params == nil {
+		params = b.ParameterMap()
+	}
 
 	switch b.Name {
 	case "SRC":
@@ -127,22 +308,50 @@ func (w *HTMLWriter) WriteBlock(b Block) {
 		if len(b.Parameters) >= 1 {
 			lang = strings.ToLower(b.Parameters[0])
 		}
-		content = w.HighlightCodeBlock(content, lang, false)
-		w.WriteString(fmt.Sprintf("<div class=\"src src-%s\">\n%s\n</div>\n", lang, content))
+		content, gutter, labelLines := w.codeLines(b.Switches, content)
+		highlightParams := map[string]string{}
+		for k, v := range params {
+			highlightParams[k] = v
+		}
+		if len(labelLines) != 0 {
+			highlightParams[":hl_lines"] = strings.TrimSpace(params[":hl_lines"] + " " + strings.Join(labelLines, " "))
+		}
+		if gutter != "" {
+			highlightParams[gutterParam] = "t"
+		}
+		content = w.highlightCodeBlock(content, lang, false, highlightParams)
+		w.WriteString(fmt.Sprintf("<div class=\"src src-%s\">\n%s\n</div>\n", lang, withLineNumbers(gutter, content)))
 	case "EXAMPLE":
-		w.WriteString(`<pre class="example">` + "\n" + html.EscapeString(content) + "\n</pre>\n")
+		content, gutter, _ := w.codeLines(b.Switches, content)
+		w.WriteString(withLineNumbers(gutter, `<pre class="example">`+"\n"+html.EscapeString(content)+"\n</pre>") + "\n")
 	case "EXPORT":
 		if len(b.Parameters) >= 1 && strings.ToLower(b.Parameters[0]) == "html" {
-			w.WriteString(content + "\n")
+			w.writeRaw(content + "\n")
 		}
+	case "VERSE":
+		w.WriteString(`<p class="verse">` + "\n" + verseLines(content) + "\n</p>\n")
 	case "QUOTE":
-		w.WriteString("<blockquote>\n" + content + "</blockquote>\n")
+		w.WriteString("<blockquote>\n" + content)
+		if len(b.Parameters) != 0 {
+			w.WriteString("<footer>&#8212; " + html.EscapeString(strings.Join(b.Parameters, " ")) + "</footer>\n")
+		}
+		w.WriteString("</blockquote>\n")
 	case "CENTER":
-		w.WriteString(`<div class="center-block" style="text-align: center; margin-left: auto; margin-right: auto;">` + "\n")
-		w.WriteString(content + "</div>\n")
+		w.WriteString(`<div class="center-block" style="text-align: center; margin-left: auto; margin-right: auto;">` + "\n" + content + "</div>\n")
 	default:
-		w.WriteString(fmt.Sprintf(`<div class="%s-block">`, strings.ToLower(b.Name)) + "\n")
-		w.WriteString(content + "</div>\n")
+		e, ok := w.SpecialBlocks[b.Name]
+		if !ok {
+			e = defaultSpecialBlock(b.Name)
+		}
+		class := ""
+		if e.Class != "" {
+			class = fmt.Sprintf(` class="%s"`, html.EscapeString(e.Class))
+		}
+		w.WriteString(fmt.Sprintf("<%s%s>\n", e.Element, class))
+		if e.Element == "details" && len(b.Parameters) != 0 {
+			w.WriteString("<summary>" + html.EscapeString(strings.Join(b.Parameters, " ")) + "</summary>\n")
+		}
+		w.WriteString(content + fmt.Sprintf("</%s>\n", e.Element))
 	}
 
 	if b.Result != nil && params[":exports"] != "code" && params[":exports"] != "none" {
@@ -150,68 +359,141 @@ func (w *HTMLWriter) WriteBlock(b Block) {
 	}
 }
 
+// defaultSpecialBlock returns the element for a special block without an entry in HTMLWriter.SpecialBlocks:
+// Blocks named like an html5 sectioning element (e.g. #+BEGIN_ASIDE) are written as that element,
+// all other blocks as <div class="NAME">.
+func defaultSpecialBlock(name string) BlockElement {
+	if name := strings.ToLower(name); html5BlockElements[name] {
+		return BlockElement{name, ""}
+	}
+	return BlockElement{"div", strings.ToLower(name)}
+}
+
+// verseLines keeps the line breaks and leading indentation of the html content of a verse block.
+func verseLines(content string) string {
+	lines := strings.Split(strings.TrimRightFunc(content, unicode.IsSpace), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		lines[i] = strings.Repeat("&#xa0;", len(line)-len(trimmed)) + strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	}
+	return strings.Join(lines, "<br />\n")
+}
+
 func (w *HTMLWriter) WriteResult(r Result) { WriteNodes(w, r.Node) }
 
 func (w *HTMLWriter) WriteInlineBlock(b InlineBlock) {
 	content := w.blockContent(strings.ToUpper(b.Name), b.Children)
 	switch b.Name {
 	case "src":
-		lang := strings.ToLower(b.Parameters[0])
-		content = w.HighlightCodeBlock(content, lang, true)
-		w.WriteString(fmt.Sprintf("<div class=\"src src-inline src-%s\">\n%s\n</div>", lang, content))
+		params, result := Block{Parameters: b.Parameters}.ParameterMap(), b.Result
+		exports := params[":exports"]
+		if w.document.Evaluate != nil {
+			if exports == "" {
+				exports = "results"
+			}
+			if exports == "results" || exports == "both" {
+				if output, ok := w.document.evaluateBlock(Block{Name: "SRC", Parameters: b.Parameters, Children: b.Children, HeaderArgs: params}, ""); ok {
+					result = inlineResult(output)
+				}
+			}
+		}
+		if exports != "results" && exports != "none" {
+			lang := strings.ToLower(b.Parameters[0])
+			content = w.highlightCodeBlock(content, lang, true, params)
+			w.WriteString(fmt.Sprintf("<div class=\"src src-inline src-%s\">\n%s\n</div>", lang, content))
+		}
+		if exports == "results" || exports == "both" {
+			WriteNodes(w, result...)
+		}
 	case "export":
 		if strings.ToLower(b.Parameters[0]) == "html" {
-			w.WriteString(content)
+			w.writeRaw(content)
 		}
 	}
 }
 
+func (w *HTMLWriter) WriteCall(c Call) { writeCall(w, w.document, c) }
+
+func (w *HTMLWriter) WriteInlineCall(c InlineCall) { writeInlineCall(w, w.document, c) }
+
 func (w *HTMLWriter) WriteDrawer(d Drawer) {
 	WriteNodes(w, d.Children...)
 }
 
 func (w *HTMLWriter) WriteKeyword(k Keyword) {
 	if k.Key == "HTML" {
-		w.WriteString(k.Value + "\n")
+		w.writeRaw(k.Value + "\n")
 	} else if k.Key == "TOC" {
 		if m := tocHeadlineMaxLvlRegexp.FindStringSubmatch(k.Value); m != nil {
 			maxLvl, _ := strconv.Atoi(m[1])
 			w.WriteOutline(w.document, maxLvl)
+		} else if kind := strings.TrimSpace(k.Value); kind == "tables" || kind == "listings" {
+			w.writeCaptionList(strings.TrimSuffix(kind, "s"))
 		}
+	} else if k.Key == "PRINT_BIBLIOGRAPHY" {
+		w.writeBibliography()
 	}
 }
 
-func (w *HTMLWriter) WriteInclude(i Include) {
-	WriteNodes(w, i.Resolve())
+// writeCaptionList writes a list of the captioned elements of kind (e.g. a list of tables).
+func (w *HTMLWriter) writeCaptionList(kind string) {
+	items := []string{}
+	for _, c := range w.document.Captions {
+		if c.Kind == kind {
+			caption := cleanHeadlineTitleForHTMLAnchorRegexp.ReplaceAllString(w.WriteNodesAsString(c.Caption...), "")
+			items = append(items, fmt.Sprintf("<li><a href=\"#%s\">%s %d: %s</a></li>\n", html.EscapeString(c.ID()), w.document.captionLabel(kind), c.Number, caption))
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	w.WriteString(fmt.Sprintf("<nav class=\"list-of-%ss\">\n<h2>%s</h2>\n<ul>\n", kind, w.document.captionLabel(kind+"s")))
+	w.WriteString(strings.Join(items, "") + "</ul>\n</nav>\n")
 }
 
+func (w *HTMLWriter) WriteInclude(i Include) { writeInclude(w, w.document, i) }
+
 func (w *HTMLWriter) WriteFootnoteDefinition(f FootnoteDefinition) {
 	w.footnotes.updateDefinition(f)
 }
 
 func (w *HTMLWriter) WriteFootnotes(d *Document) {
-	if w.document.GetOption("f") == "nil" || len(w.footnotes.list) == 0 {
+	if w.document.GetOption("f") == "nil" {
 		return
 	}
-	w.WriteString(`<div class="footnotes">` + "\n")
-	w.WriteString(`<hr class="footnotes-separatator">` + "\n")
-	w.WriteString(`<div class="footnote-definitions">` + "\n")
-	for i, definition := range w.footnotes.list {
-		id := i + 1
-		if definition == nil {
+	ids := []int{}
+	for i := w.footnotes.written; i < len(w.footnotes.list); i++ {
+		if w.footnotes.list[i] == nil {
 			name := ""
 			for k, v := range w.footnotes.mapping {
 				if v == i {
 					name = k
 				}
 			}
-			w.log.Printf("Missing footnote definition for [fn:%s] (#%d)", name, id)
-			continue
+			w.log.Printf("Missing footnote definition for [fn:%s] (#%d)", name, i+1)
+		} else if !w.footnotes.sidenotes[i] {
+			ids = append(ids, i+1)
 		}
+	}
+	w.footnotes.written = len(w.footnotes.list)
+	if len(ids) == 0 {
+		return
+	}
+	w.WriteString(`<div class="footnotes">` + "\n")
+	w.WriteString(`<hr class="footnotes-separatator">` + "\n")
+	w.WriteString(`<div class="footnote-definitions">` + "\n")
+	for _, id := range ids {
 		w.WriteString(`<div class="footnote-definition">` + "\n")
 		w.WriteString(fmt.Sprintf(`<sup id="footnote-%d"><a href="#footnote-reference-%d">%d</a></sup>`, id, id, id) + "\n")
+		if references := w.footnotes.references[id-1]; references > 1 {
+			backlinks := make([]string, references-1)
+			for i := range backlinks {
+				backlinks[i] = fmt.Sprintf(`<a href="#footnote-reference-%d-%d">&#8617;%d</a>`, id, i+2, i+2)
+			}
+			w.WriteString(`<span class="footnote-backrefs">` + strings.Join(backlinks, " ") + "</span>\n")
+		}
 		w.WriteString(`<div class="footnote-body">` + "\n")
-		WriteNodes(w, definition.Children...)
+		WriteNodes(w, w.footnotes.list[id-1].Children...)
 		w.WriteString("</div>\n</div>\n")
 	}
 	w.WriteString("</div>\n</div>\n")
@@ -227,18 +509,31 @@ func (w *HTMLWriter) WriteOutline(d *Document, maxLvl int) {
 	}
 }
 
+func (w *HTMLWriter) isInOutline(h *Headline, maxLvl int) bool {
+	if (maxLvl != 0 && h.Lvl > maxLvl) || h.IsExcluded(w.document) || w.document.isListHeadline(*h) {
+		return false
+	}
+	unnumbered, _ := h.Properties.Get("UNNUMBERED")
+	return unnumbered != "notoc"
+}
+
 func (w *HTMLWriter) writeSection(section *Section, maxLvl int) {
-	if (maxLvl != 0 && section.Headline.Lvl > maxLvl) || section.Headline.IsExcluded(w.document) {
+	h := section.Headline
+	if !w.isInOutline(h, maxLvl) {
 		return
 	}
 	// NOTE: To satisfy hugo ExtractTOC() check we cannot use `<li>\n` here. Doesn't really matter, just a note.
 	w.WriteString("<li>")
-	h := section.Headline
+	w.inOutline = true
 	title := cleanHeadlineTitleForHTMLAnchorRegexp.ReplaceAllString(w.WriteNodesAsString(h.Title...), "")
+	w.inOutline = false
+	if number, ok := w.sectionNumbers[h.Index]; ok {
+		title = fmt.Sprintf(`<span class="section-number-%d">%s</span> %s`, h.Lvl+1, number, title)
+	}
 	w.WriteString(fmt.Sprintf("<a href=\"#%s\">%s</a>\n", h.ID(), title))
 	hasChildren := false
 	for _, section := range section.Children {
-		hasChildren = hasChildren || maxLvl == 0 || section.Headline.Lvl <= maxLvl
+		hasChildren = hasChildren || w.isInOutline(section.Headline, maxLvl)
 	}
 	if hasChildren {
 		w.WriteString("<ul>\n")
@@ -254,11 +549,44 @@ func (w *HTMLWriter) WriteHeadline(h Headline) {
 	if h.IsExcluded(w.document) {
 		return
 	}
+	if w.document.isListHeadline(h) {
+		w.writeListHeadline(h)
+		return
+	}
 
 	w.WriteString(fmt.Sprintf(`<div id="outline-container-%s" class="outline-%d">`, h.ID(), h.Lvl+1) + "\n")
 	w.WriteString(fmt.Sprintf(`<h%d id="%s">`, h.Lvl+1, h.ID()) + "\n")
+	if number, ok := w.sectionNumbers[h.Index]; ok {
+		w.WriteString(fmt.Sprintf(`<span class="section-number-%d">%s</span>`, h.Lvl+1, number) + "\n")
+	}
+	w.writeHeadlineTitle(h)
+	w.WriteString(fmt.Sprintf("\n</h%d>\n", h.Lvl+1))
+	isTopLevel := w.headline == nil
+	content := w.writeHeadlineChildren(h)
+	if content != "" {
+		w.WriteString(fmt.Sprintf(`<div id="outline-text-%s" class="outline-text-%d">`, h.ID(), h.Lvl+1) + "\n" + content + "</div>\n")
+	}
+	if isTopLevel && w.FootnoteStyle == SectionFootnotes {
+		w.WriteFootnotes(w.document)
+	}
+	w.WriteString("</div>\n")
+}
+
+func (w *HTMLWriter) writeListHeadline(h Headline) {
+	if !w.inHeadlineList {
+		w.WriteString("<ul class=\"headline-list\">\n")
+		defer w.WriteString("</ul>\n")
+	}
+	w.WriteString(fmt.Sprintf(`<li id="%s">`, h.ID()) + "\n")
+	w.writeHeadlineTitle(h)
+	w.WriteString("\n")
+	w.WriteString(w.writeHeadlineChildren(h))
+	w.WriteString("</li>\n")
+}
+
+func (w *HTMLWriter) writeHeadlineTitle(h Headline) {
 	if w.document.GetOption("todo") != "nil" && h.Status != "" {
-		w.WriteString(fmt.Sprintf(`<span class="todo">%s</span>`, h.Status) + "\n")
+		w.WriteString(fmt.Sprintf(`<span class="todo">%s</span>`, html.EscapeString(h.Status)) + "\n")
 	}
 	if w.document.GetOption("pri") != "nil" && h.Priority != "" {
 		w.WriteString(fmt.Sprintf(`<span class="priority">[%s]</span>`, h.Priority) + "\n")
@@ -273,11 +601,38 @@ func (w *HTMLWriter) WriteHeadline(h Headline) {
 		w.WriteString("&#xa0;&#xa0;&#xa0;")
 		w.WriteString(fmt.Sprintf(`<span class="tags">%s</span>`, strings.Join(tags, "&#xa0;")))
 	}
-	w.WriteString(fmt.Sprintf("\n</h%d>\n", h.Lvl+1))
-	if content := w.WriteNodesAsString(h.Children...); content != "" {
-		w.WriteString(fmt.Sprintf(`<div id="outline-text-%s" class="outline-text-%d">`, h.ID(), h.Lvl+1) + "\n" + content + "</div>\n")
+}
+
+// writeHeadlineChildren returns the children of h as html. Consecutive list headlines (see H export option)
+// are grouped into a single list.
+func (w *HTMLWriter) writeHeadlineChildren(h Headline) string {
+	parentHeadline, inHeadlineList := w.headline, w.inHeadlineList
+	w.headline = &h
+	w.inHeadlineList = false
+	defer func() { w.headline, w.inHeadlineList = parentHeadline, inHeadlineList }()
+	original := w.Builder
+	w.Builder = strings.Builder{}
+	for i, n := range h.Children {
+		child, isHeadline := n.(Headline)
+		isListHeadline := isHeadline && !child.IsExcluded(w.document) && w.document.isListHeadline(child)
+		if isListHeadline && !w.inHeadlineList {
+			w.WriteString("<ul class=\"headline-list\">\n")
+			w.inHeadlineList = true
+		}
+		WriteNodes(w, h.Children[i:i+1]...) // a subslice keeps the address of n - see nodeLines
+		if next := i + 1; w.inHeadlineList && (next == len(h.Children) || !w.isNextListHeadline(h.Children[next])) {
+			w.WriteString("</ul>\n")
+			w.inHeadlineList = false
+		}
 	}
-	w.WriteString("</div>\n")
+	content := w.String()
+	w.Builder = original
+	return content
+}
+
+func (w *HTMLWriter) isNextListHeadline(n Node) bool {
+	h, ok := n.(Headline)
+	return ok && (h.IsExcluded(w.document) || w.document.isListHeadline(h))
 }
 
 func (w *HTMLWriter) WriteText(t Text) {
@@ -301,6 +656,18 @@ func (w *HTMLWriter) WriteEmphasis(e Emphasis) {
 }
 
 func (w *HTMLWriter) WriteLatexFragment(l LatexFragment) {
+	if w.MathML {
+		source, display := String(l.Content), l.OpeningPair != `\(` && l.OpeningPair != "$"
+		if strings.HasPrefix(l.OpeningPair, `\begin`) {
+			source = l.OpeningPair + source + l.ClosingPair
+		}
+		out, err := latexToMathML(source, display)
+		if err == nil {
+			w.WriteString(out)
+			return
+		}
+		w.log.Printf("Could not convert latex fragment to MathML: %s", err)
+	}
 	w.WriteString(l.OpeningPair)
 	WriteNodes(w, l.Content...)
 	w.WriteString(l.ClosingPair)
@@ -322,9 +689,56 @@ func (w *HTMLWriter) WriteFootnoteLink(l FootnoteLink) {
 	if w.document.GetOption("f") == "nil" {
 		return
 	}
+	if l.Definition == nil && w.FootnoteStyle != EndFootnotes {
+		l.Definition = w.document.footnotes[l.Name]
+	}
 	i := w.footnotes.add(l)
-	id := i + 1
-	w.WriteString(fmt.Sprintf(`<sup class="footnote-reference"><a id="footnote-reference-%d" href="#footnote-%d">%d</a></sup>`, id, id, id))
+	if w.inOutline {
+		// references in the outline are not linked and thus not counted
+		w.footnotes.references[i]--
+		w.WriteString(fmt.Sprintf(`<sup class="footnote-reference">%d</sup>`, i+1))
+		return
+	}
+	id, referenceID := i+1, fmt.Sprintf("footnote-reference-%d", i+1)
+	if references := w.footnotes.references[i]; references > 1 {
+		referenceID += fmt.Sprintf("-%d", references)
+	}
+	w.WriteString(fmt.Sprintf(`<sup class="footnote-reference"><a id="%s" href="#footnote-%d">%d</a></sup>`, referenceID, id, id))
+	if definition := w.footnotes.list[i]; w.FootnoteStyle == Sidenotes && w.footnotes.references[i] == 1 && definition != nil {
+		if p, ok := singleParagraph(definition.Children); ok {
+			w.WriteString(fmt.Sprintf(`<span class="sidenote" id="footnote-%d"><sup>%d</sup> `, id, id))
+			WriteNodes(w, p.Children...)
+			w.WriteString("</span>")
+			w.footnotes.sidenotes[i] = true
+		}
+	}
+}
+
+func (w *HTMLWriter) WriteCitation(c Citation) {
+	if out := w.document.formatCitation(c, htmlCitationMarkup); out != "" {
+		w.WriteString(`<span class="citation">` + out + "</span>")
+	}
+}
+
+var htmlCitationMarkup = citationMarkup{
+	escape:   html.EscapeString,
+	emphasis: func(s string) string { return "<em>" + s + "</em>" },
+	link: func(url, text string) string {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), text)
+	},
+}
+
+// writeBibliography writes the formatted entries of the bibliography that are cited in the document (see #+PRINT_BIBLIOGRAPHY:).
+func (w *HTMLWriter) writeBibliography() {
+	entries := w.document.formatBibliography(htmlCitationMarkup)
+	if len(entries) == 0 {
+		return
+	}
+	w.WriteString("<div class=\"bibliography\">\n<ul>\n")
+	for _, e := range entries {
+		w.WriteString(fmt.Sprintf("<li id=\"%s\">%s</li>\n", html.EscapeString(citationID(e[0])), e[1]))
+	}
+	w.WriteString("</ul>\n</div>\n")
 }
 
 func (w *HTMLWriter) WriteTimestamp(t Timestamp) {
@@ -344,6 +758,34 @@ func (w *HTMLWriter) WriteTimestamp(t Timestamp) {
 }
 
 func (w *HTMLWriter) WriteRegularLink(l RegularLink) {
+	if m := codeRefLinkRegexp.FindStringSubmatch(l.URL); m != nil {
+		if ref, ok := w.document.CodeRefs[m[1]]; ok {
+			description := m[1]
+			if l.Description != nil {
+				description = w.WriteNodesAsString(l.Description...)
+			} else if ref.RemoveLabel {
+				description = strconv.Itoa(ref.Line)
+			}
+			w.WriteString(fmt.Sprintf(`<a href="#%s" class="coderef">%s</a>`, codeRefID(ref.Block, m[1]), description))
+			return
+		}
+	}
+	if c := w.document.namedCaptioned(l.URL); c != nil && l.Protocol == "" {
+		description := strconv.Itoa(c.Number)
+		if l.Description != nil {
+			description = w.WriteNodesAsString(l.Description...)
+		}
+		w.WriteString(fmt.Sprintf(`<a href="#%s">%s</a>`, html.EscapeString(c.ID()), description))
+		return
+	}
+	if h := w.document.linkedHeadline(l); h != nil {
+		description := cleanHeadlineTitleForHTMLAnchorRegexp.ReplaceAllString(w.WriteNodesAsString(h.Title...), "")
+		if l.Description != nil {
+			description = w.WriteNodesAsString(l.Description...)
+		}
+		w.WriteString(fmt.Sprintf(`<a href="#%s">%s</a>`, html.EscapeString(h.ID()), description))
+		return
+	}
 	url := html.EscapeString(l.URL)
 	if l.Protocol == "file" {
 		url = url[len("file:"):]
@@ -364,10 +806,24 @@ func (w *HTMLWriter) WriteRegularLink(l RegularLink) {
 	switch l.Kind() {
 	case "image":
 		if l.Description == nil {
-			w.WriteString(fmt.Sprintf(`<img src="%s" alt="%s" title="%s" />`, url, url, url))
+			src := url
+			if isRelative := l.Protocol == "file" || l.Protocol == ""; isRelative && w.EmbedImages {
+				if dataURI, ok := w.embedImage(strings.TrimPrefix(l.URL, "file:")); ok {
+					src = dataURI
+				}
+			}
+			w.WriteString(fmt.Sprintf(`<img src="%s" alt="%s" title="%s" />`, src, url, url))
 		} else {
-			description := strings.TrimPrefix(String(l.Description), "file:")
-			w.WriteString(fmt.Sprintf(`<a href="%s"><img src="%s" alt="%s" /></a>`, url, description, description))
+			description, src := strings.TrimPrefix(String(l.Description), "file:"), ""
+			if w.EmbedImages {
+				if dataURI, ok := w.embedImage(description); ok {
+					src = dataURI
+				}
+			}
+			if src == "" {
+				src = description
+			}
+			w.WriteString(fmt.Sprintf(`<a href="%s"><img src="%s" alt="%s" /></a>`, url, src, description))
 		}
 	case "video":
 		if l.Description == nil {
@@ -386,16 +842,7 @@ func (w *HTMLWriter) WriteRegularLink(l RegularLink) {
 }
 
 func (w *HTMLWriter) WriteMacro(m Macro) {
-	if macro := w.document.Macros[m.Name]; macro != "" {
-		for i, param := range m.Parameters {
-			macro = strings.Replace(macro, fmt.Sprintf("$%d", i+1), param, -1)
-		}
-		macroDocument := w.document.Parse(strings.NewReader(macro), w.document.Path)
-		if macroDocument.Error != nil {
-			w.log.Printf("bad macro: %s -> %s: %v", m.Name, macro, macroDocument.Error)
-		}
-		WriteNodes(w, macroDocument.Nodes...)
-	}
+	w.document.writeMacro(w, m, w.headline)
 }
 
 func (w *HTMLWriter) WriteList(l List) {
@@ -496,7 +943,12 @@ func (w *HTMLWriter) WriteNodeWithMeta(n NodeWithMeta) {
 			}
 			caption += w.WriteNodesAsString(ns...)
 		}
-		out = fmt.Sprintf("<figure>\n%s<figcaption>\n%s\n</figcaption>\n</figure>\n", out, caption)
+		if c := n.captioned(); c != nil {
+			label := fmt.Sprintf(`<span class="caption-number">%s %d:</span> `, w.document.captionLabel(c.Kind), c.Number)
+			out = fmt.Sprintf("<figure id=\"%s\" class=\"%s\">\n%s<figcaption>\n%s%s\n</figcaption>\n</figure>\n", html.EscapeString(c.ID()), c.Kind, out, label, caption)
+		} else {
+			out = fmt.Sprintf("<figure>\n%s<figcaption>\n%s\n</figcaption>\n</figure>\n", out, caption)
+		}
 	}
 	w.WriteString(out)
 }
@@ -563,7 +1015,11 @@ func (w *HTMLWriter) withHTMLAttributes(input string, kvs ...string) string {
 	}
 	out, node := strings.Builder{}, nodes[0]
 	for i := 0; i < len(kvs)-1; i += 2 {
-		node.Attr = setHTMLAttribute(node.Attr, strings.TrimPrefix(kvs[i], ":"), kvs[i+1])
+		key, value := strings.TrimPrefix(kvs[i], ":"), kvs[i+1]
+		if w.Safe && strings.ToLower(key) == "id" {
+			value = userContentIDPrefix + value
+		}
+		node.Attr = setHTMLAttribute(node.Attr, key, value)
 	}
 	err = h.Render(&out, nodes[0])
 	if err != nil {
@@ -586,6 +1042,70 @@ func (w *HTMLWriter) blockContent(name string, children []Node) string {
 	}
 }
 
+// codeLines applies the coderef switches to the content of a src or example block. It returns the content,
+// the line numbers and coderef anchors for the numbered lines column ("" if neither exist) and the lines containing coderefs.
+func (w *HTMLWriter) codeLines(s Switches, content string) (string, string, []string) {
+	lines, gutter, labelLines := strings.Split(content, "\n"), make([]string, 0), []string{}
+	labelRegexp, hasGutter := codeRefLabelRegexp(s.LabelFormat), s.NumberLines != ""
+	for i, line := range lines {
+		n := ""
+		if s.NumberLines != "" {
+			n = strconv.Itoa(s.FirstLine + i)
+		}
+		if m := labelRegexp.FindStringSubmatch(line); m != nil {
+			if s.RemoveLabels {
+				lines[i] = labelRegexp.ReplaceAllString(line, "")
+			}
+			n, hasGutter = fmt.Sprintf(`<span id="%s" class="coderef-off">%s</span>`, codeRefID(s.CodeRefBlock, m[1]), n), true
+			labelLines = append(labelLines, strconv.Itoa(i+1))
+		}
+		gutter = append(gutter, n)
+	}
+	if !hasGutter {
+		return content, "", nil
+	}
+	return strings.Join(lines, "\n"), strings.Join(gutter, "\n"), labelLines
+}
+
+// withLineNumbers adds a column containing line numbers / coderef anchors (see codeLines) next to the content.
+func withLineNumbers(gutter, content string) string {
+	if gutter == "" {
+		return content
+	}
+	return "<table class=\"linenos\">\n<tr>\n" +
+		"<td>\n<pre class=\"linenr\">\n" + gutter + "\n</pre>\n</td>\n" +
+		"<td>\n" + content + "\n</td>\n" +
+		"</tr>\n</table>"
+}
+
+// gutterParam is set in the params passed to HighlightCodeBlockWithParams for blocks written with a gutter (see HasGutter).
+const gutterParam = ":gutter"
+
+// HasGutter returns true if HTMLWriter writes a gutter with line numbers / coderef anchors next to the
+// highlighted block (e.g. for -n). HighlightCodeBlockWithParams implementations should not number the lines of such blocks again.
+func HasGutter(params map[string]string) bool { return params[gutterParam] != "" }
+
+// HighlightedLines returns the line ranges of the :hl_lines header argument (e.g. "1 3-5") in params.
+// HTMLWriter adds the lines containing coderefs to it before calling HighlightCodeBlockWithParams.
+func HighlightedLines(params map[string]string) [][2]int {
+	ranges := [][2]int{}
+	for _, r := range strings.Fields(strings.Trim(params[":hl_lines"], `"`)) {
+		bounds := strings.SplitN(r, "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges
+}
+
 func setHTMLAttribute(attributes []h.Attribute, k, v string) []h.Attribute {
 	for i, a := range attributes {
 		if strings.ToLower(a.Key) == strings.ToLower(k) {
@@ -610,11 +1130,32 @@ func isParagraphNodeSlice(ns []Node) bool {
 	return true
 }
 
+// singleParagraph returns the only paragraph of nodes - ignoring line breaks and empty paragraphs.
+func singleParagraph(nodes []Node) (Paragraph, bool) {
+	paragraphs := []Paragraph{}
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case Paragraph:
+			if len(n.Children) != 0 {
+				paragraphs = append(paragraphs, n)
+			}
+		case LineBreak:
+		default:
+			return Paragraph{}, false
+		}
+	}
+	if len(paragraphs) != 1 {
+		return Paragraph{}, false
+	}
+	return paragraphs[0], true
+}
+
 func (fs *footnotes) add(f FootnoteLink) int {
 	if i, ok := fs.mapping[f.Name]; ok && f.Name != "" {
+		fs.references[i]++
 		return i
 	}
-	fs.list = append(fs.list, f.Definition)
+	fs.list, fs.references = append(fs.list, f.Definition), append(fs.references, 1)
 	i := len(fs.list) - 1
 	if f.Name != "" {
 		fs.mapping[f.Name] = i
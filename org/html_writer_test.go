@@ -3,6 +3,7 @@ package org
 import (
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 type ExtendedHTMLWriter struct {
@@ -69,3 +70,301 @@ func TestPrettyRelativeLinks(t *testing.T) {
 		})
 	}
 }
+
+func TestStandaloneHTMLWriter(t *testing.T) {
+	config := New().Silent()
+	config.FS = fstest.MapFS{"docs/image.png": {Data: []byte("png")}}
+	input := "#+TITLE: Some <title>\n#+AUTHOR: author\n#+LANGUAGE: de\n#+HTML_HEAD: <link rel=\"icon\" href=\"icon.png\">\n\n[[file:image.png]] [[https://example.com/image.png]]\n"
+	writer := NewHTMLWriter()
+	writer.Standalone, writer.Stylesheet, writer.EmbedImages = true, "p { margin: 0; }", true
+	actual, err := config.Parse(strings.NewReader(input), "docs/post.org").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		"<!DOCTYPE html>\n<html lang=\"de\">\n<head>\n",
+		"<title>Some &lt;title&gt;</title>\n",
+		`<meta name="author" content="author">`,
+		"<style>\np { margin: 0; }\n</style>\n",
+		`<link rel="icon" href="icon.png">` + "\n</head>\n<body>\n",
+		`<img src="data:image/png;base64,cG5n" alt="image.png" title="image.png" />`,
+		`<img src="https://example.com/image.png"`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+	if !strings.HasSuffix(actual, "</body>\n</html>\n") {
+		t.Errorf("expected output to end with </html>:\n%s", actual)
+	}
+	osConfig := New().Silent()
+	osConfig.ReadFile = func(string) ([]byte, error) { return []byte("png"), nil }
+	for _, config := range []*Configuration{osConfig, config} {
+		d := config.Parse(strings.NewReader("[[file:/etc/passwd.png]] [[file:../../image.png]]\n"), "docs/post.org")
+		writer := NewHTMLWriter()
+		writer.EmbedImages = true
+		if actual, err := d.Write(writer); err != nil || strings.Contains(actual, "data:") {
+			t.Errorf("expected images outside of the document directory not to be embedded (%v):\n%s", err, actual)
+		}
+	}
+}
+
+func TestSlugHeadlineIDs(t *testing.T) {
+	config := New().Silent()
+	config.HeadlineIDs = SlugHeadlineIDs
+	input := "* Some /Headline/\n* Some headline\n** Some headline\n* Custom\n:PROPERTIES:\n:CUSTOM_ID: custom\n:END:\n* Custom\n\n[[*Some headline]]\n"
+	actual, err := config.Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		`<li><a href="#some-headline">Some <em>Headline</em></a>`,
+		`<h2 id="some-headline">`,
+		`<h2 id="some-headline-1">`,
+		`<h3 id="some-headline-2">`,
+		`<h2 id="custom">`,
+		`<h2 id="custom-1">`,
+		`<a href="#some-headline-1">Some headline</a>`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+
+	input = "* Intro\n* Explicit\n:PROPERTIES:\n:CUSTOM_ID: x\n:END:\n* Explicit\n* Later\n:PROPERTIES:\n:CUSTOM_ID: intro\n:END:\n"
+	actual, err = config.Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{`<h2 id="intro-1">`, `<h2 id="x">`, `<h2 id="explicit">`, `<h2 id="intro">`} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+
+	input = "* Table of Contents\n* Footnotes\n* Title slide\n* Footnote 1\n* Table 1\n* Table\n"
+	actual, err = config.Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{`<h2 id="table-of-contents-1">`, `<h2 id="footnotes-1">`, `<h2 id="title-slide-1">`,
+		`<h2 id="footnote-1-1">`, `<h2 id="table-1-1">`, `<h2 id="table">`} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+}
+
+var safeHTMLWriterTests = map[string]string{
+	"#+BEGIN_EXPORT html\n<script>alert(1)</script>\n#+END_EXPORT\n":   "&lt;script&gt;alert(1)&lt;/script&gt;",
+	"@@html:<b onclick=alert(1)>b</b>@@\n":                             "<p>&lt;b onclick=alert(1)&gt;b&lt;/b&gt;</p>",
+	"#+HTML: <iframe src=x></iframe>\n":                                "&lt;iframe src=x&gt;&lt;/iframe&gt;",
+	"[[javascript:alert(1)][a]] [[https://example.com][b]] [[#c][c]]":  `<p><a>a</a> <a href="https://example.com">b</a> <a href="#c">c</a></p>`,
+	"#+ATTR_HTML: :onerror alert(1) :style x :class y\n[[file:a.png]]": `<img src="a.png" alt="a.png" title="a.png" class="y"/>`,
+	"#+ATTR_HTML: :id footnote-1\n[[file:a.png]]":                      `<img src="a.png" alt="a.png" title="a.png" id="user-content-footnote-1"/>`,
+}
+
+func TestSafeHTMLWriter(t *testing.T) {
+	for org, expected := range safeHTMLWriterTests {
+		t.Run(org, func(t *testing.T) {
+			writer := NewHTMLWriter()
+			writer.Safe = true
+			actual, err := New().Silent().Parse(strings.NewReader(org), "").Write(writer)
+			if err != nil {
+				t.Errorf("%s\n got error: %s", org, err)
+			} else if actual := strings.TrimSpace(actual); actual != expected {
+				t.Errorf("%s:\n%s'", org, diff(actual, expected))
+			}
+		})
+	}
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	actual, err := sanitizeHTML(`<div onclick="x" class="a"><script>alert(1)</script><custom>text</custom><a href=" java	script:alert(1)">link</a><img src="data:image/png;base64,AA"><a href="data:text/html,x">data</a><p>unclosed`)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	expected := `<div class="a">text<a>link</a><img src="data:image/png;base64,AA"/><a>data</a><p>unclosed</p></div>`
+	if actual != expected {
+		t.Errorf("%s", diff(actual, expected))
+	}
+}
+
+func TestFootnoteStyles(t *testing.T) {
+	input := "* a\nreference [fn:1] and again [fn:1]\n* b\nlong footnote [fn:2]\n* Footnotes\n[fn:1] short\n\n[fn:2] long\n- with a list\n"
+	for style, expected := range map[FootnoteStyle][]string{
+		SectionFootnotes: {
+			`<a id="footnote-reference-1-2" href="#footnote-1">1</a></sup></p>` + "\n</div>\n" + `<div class="footnotes">`,
+			`<span class="footnote-backrefs"><a href="#footnote-reference-1-2">&#8617;2</a></span>`,
+			`<a id="footnote-reference-2" href="#footnote-2">2</a></sup></p>` + "\n</div>\n" + `<div class="footnotes">`,
+		},
+		Sidenotes: {
+			`<a id="footnote-reference-1" href="#footnote-1">1</a></sup><span class="sidenote" id="footnote-1"><sup>1</sup> short</span> and again`,
+			`<a id="footnote-reference-1-2" href="#footnote-1">1</a></sup></p>`,
+			`<a id="footnote-reference-2" href="#footnote-2">2</a></sup></p>`,
+			`<sup id="footnote-2"><a href="#footnote-reference-2">2</a></sup>`,
+		},
+	} {
+		writer := NewHTMLWriter()
+		writer.FootnoteStyle = style
+		actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+		if err != nil {
+			t.Fatalf("got error: %s", err)
+		}
+		for _, expected := range expected {
+			if !strings.Contains(actual, expected) {
+				t.Errorf("%d: expected output to contain %q:\n%s", style, expected, actual)
+			}
+		}
+	}
+}
+
+func TestLocalizedCaptions(t *testing.T) {
+	input := "#+LANGUAGE: de\n#+CAPTION: Zahlen\n#+NAME: zahlen\n| 1 |\n\nsiehe Tabelle [[zahlen]]\n#+TOC: tables\n"
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		`<span class="caption-number">Tabelle 1:</span> Zahlen`,
+		`siehe Tabelle <a href="#zahlen">1</a>`,
+		"<h2>Tabellenverzeichnis</h2>",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestCaptionNumbering(t *testing.T) {
+	input := "#+CAPTION: first\n| 1 |\n\n* hidden :noexport:\n#+CAPTION: hidden\n| 2 |\n* shown\n#+CAPTION: second\n#+NAME: second\n| 3 |\n\nsee [[second]] {{{table(third)}}}\n#+TOC: tables\n"
+	d := New().Silent().Parse(strings.NewReader(input), "")
+	d.Macros["table"] = "#+CAPTION: $1\n| 4 |"
+	actual, err := d.Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		`<span class="caption-number">Table 1:</span> first`,
+		`<span class="caption-number">Table 2:</span> second`,
+		`see <a href="#second">2</a>`,
+		`<span class="caption-number">Table 3:</span> third`,
+		`<li><a href="#second">Table 2: second</a></li>`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+	if strings.Contains(actual, "hidden") {
+		t.Errorf("expected output to not contain the caption of the excluded table:\n%s", actual)
+	}
+}
+
+func TestNumericCitations(t *testing.T) {
+	config := New().Silent()
+	config.FS = fstest.MapFS{"docs/refs.json": {Data: []byte(`[
+  {"id": "doe20", "type": "article-journal", "title": "On <things>", "container-title": "Journal",
+   "author": [{"family": "Doe", "given": "Jane"}], "issued": {"date-parts": [[2020]]}},
+  {"id": "roe19", "type": "book", "title": "Stuff", "author": [{"family": "Roe", "given": "Rick"}],
+   "issued": {"date-parts": [[2019]]}}
+]`)}}
+	input := "#+BIBLIOGRAPHY: refs.json\n#+CITE_EXPORT: basic numeric\n\n[cite:@roe19] [cite/t:@doe20 p. 3] [cite:@roe19;@doe20]\n#+PRINT_BIBLIOGRAPHY:\n"
+	actual, err := config.Parse(strings.NewReader(input), "docs/post.org").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		`<span class="citation">[<a href="#citation-roe19">1</a>]</span>`,
+		`<span class="citation">Doe [<a href="#citation-doe20">2</a>, p. 3]</span>`,
+		`<span class="citation">[<a href="#citation-roe19">1</a>, <a href="#citation-doe20">2</a>]</span>`,
+		`<li id="citation-roe19">[1] Roe, Rick. <em>Stuff</em>. 2019.</li>`,
+		`<li id="citation-doe20">[2] Doe, Jane. On &lt;things&gt;. <em>Journal</em>. 2020.</li>`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestCitationsThatFailToParse(t *testing.T) {
+	config := New().Silent()
+	config.FS = fstest.MapFS{"refs.json": {Data: []byte(`[
+  {"id": "doe20", "type": "book", "title": "Things", "author": [{"family": "Doe", "given": "Jane"}], "issued": {"date-parts": [[2020]]}},
+  {"id": "roe19", "type": "book", "title": "Stuff", "author": [{"family": "Roe", "given": "Rick"}], "issued": {"date-parts": [[2019]]}}
+]`)}}
+	input := "#+BIBLIOGRAPHY: refs.json\n#+CITE_EXPORT: basic numeric\n\n[cite:@roe19;no key;@doe20] [cite:@doe20]\n#+PRINT_BIBLIOGRAPHY:\n"
+	actual, err := config.Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !strings.Contains(actual, `<li id="citation-doe20">[1] Doe, Jane.`) || strings.Contains(actual, "citation-roe19") {
+		t.Errorf("expected only doe20 to be cited:\n%s", actual)
+	}
+}
+
+func TestCitationsWithoutCitationWriter(t *testing.T) {
+	type writerWithoutCitations struct{ Writer }
+	htmlWriter := NewHTMLWriter()
+	htmlWriter.ExtendingWriter = writerWithoutCitations{htmlWriter}
+	actual, err := New().Silent().Parse(strings.NewReader("see [cite/t:@doe20 p. 3]\n"), "").Write(htmlWriter)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if expected := "<p>see [cite/t:@doe20 p. 3]</p>\n"; actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
+
+func TestSpecialBlocks(t *testing.T) {
+	input := "#+BEGIN_WARNING\nwarning\n#+END_WARNING\n#+BEGIN_NOTE\nnote\n#+END_NOTE\n#+BEGIN_ASIDE\naside\n#+END_ASIDE\n"
+	writer := NewHTMLWriter()
+	writer.SpecialBlocks = map[string]BlockElement{"NOTE": {"aside", "note"}}
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		"<div class=\"warning\">\n<p>warning</p>\n</div>",
+		"<aside class=\"note\">\n<p>note</p>\n</aside>",
+		"<aside>\n<p>aside</p>\n</aside>",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestCodeRefIDs(t *testing.T) {
+	input := "#+BEGIN_SRC sh\necho a (ref:x)\n#+END_SRC\n\n#+BEGIN_SRC sh\necho b (ref:x)\n#+END_SRC\n\n[[(x)]]\n"
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewHTMLWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, s := range []string{`id="coderef-1-x"`, `id="coderef-2-x"`, `href="#coderef-2-x"`} {
+		if !strings.Contains(actual, s) {
+			t.Errorf("expected %s in\n%s", s, actual)
+		}
+	}
+}
+
+func TestHighlightCodeBlockHooks(t *testing.T) {
+	input := "#+BEGIN_SRC go :hl_lines 2\na\nb\n#+END_SRC\n"
+	w := NewHTMLWriter()
+	w.HighlightCodeBlock = func(source, lang string, inline bool) string { return "<pre>" + lang + ": " + source + "</pre>" }
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(w)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if !strings.Contains(actual, "<pre>go: a\nb</pre>") {
+		t.Errorf("expected HighlightCodeBlock to be used:\n%s", actual)
+	}
+	w = NewHTMLWriter()
+	w.HighlightCodeBlockWithParams = func(source, lang string, inline bool, params map[string]string) string {
+		return "<pre>" + params[":hl_lines"] + "</pre>"
+	}
+	actual, err = New().Silent().Parse(strings.NewReader(input), "").Write(w)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if !strings.Contains(actual, "<pre>2</pre>") {
+		t.Errorf("expected HighlightCodeBlockWithParams to be used:\n%s", actual)
+	}
+}
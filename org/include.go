@@ -0,0 +1,298 @@
+package org
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var includeFileRegexp = regexp.MustCompile(`^"([^"]+)"(.*)$`)
+var includeArgumentRegexp = regexp.MustCompile(`"[^"]*"|\S+`)
+var includeLinesRegexp = regexp.MustCompile(`^(\d*)-(\d*)$`)
+var customIDPropertyRegexp = regexp.MustCompile(`(?i)^\s*:CUSTOM_ID:\s+(\S+)\s*$`)
+
+type includeOptions struct {
+	path, selector, kind, lang string
+	lines                      string
+	minLvl                     int
+	onlyContents               bool
+}
+
+// parseInclude parses #+INCLUDE keywords. Org mode content is parsed as part of the document,
+// src, example and export blocks are resolved lazily on write.
+// Supported options: :lines "FROM-TO", :minlevel N, :only-contents t and FILE::*HEADLINE / FILE::#CUSTOM_ID selectors.
+func (d *Document) parseInclude(k Keyword, i int) (int, Node) {
+	include := Include{Keyword: k}
+	include.setResolve(func(context.Context) Node {
+		d.Log.Printf("Bad include %#v", k)
+		return k
+	})
+	o, err := d.parseIncludeOptions(k.Value)
+	if err != nil {
+		d.Log.Printf("Bad include %#v: %s", k, err)
+		return 1, include
+	}
+	path, err := d.resolvePath(o.path)
+	if err != nil {
+		d.addFileDiagnostic(d.line(i), k, err)
+	}
+	if o.kind != "" {
+		include.setResolve(func(ctx context.Context) Node {
+			if err != nil {
+				d.Log.Printf("Bad include %#v: %s", k, err)
+				return k
+			}
+			bs, err := d.readFileContext(ctx, path)
+			if err != nil {
+				d.Log.Printf("Bad include %#v: %s", k, err)
+				return k
+			}
+			lines, err := selectIncludeLines(strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n"), o.lines)
+			if err != nil {
+				d.Log.Printf("Bad include %#v: %s", k, err)
+				return k
+			}
+			parameters := []string{}
+			if o.lang != "" {
+				parameters = append(parameters, o.lang)
+			}
+			return Block{Name: strings.ToUpper(o.kind), Parameters: parameters, Children: d.parseRawInline(strings.Join(lines, "\n") + "\n")}
+		})
+		return 1, include
+	}
+	if err == nil {
+		if len(d.includeStack) == 1 {
+			d.includeLine = i + 1
+		}
+		if include.Children, err = d.includeOrg(path, o); err != nil {
+			d.addFileDiagnostic(d.line(i), k, err)
+		}
+	}
+	if err != nil {
+		d.Log.Printf("Bad include %#v: %s", k, err)
+		include.setResolve(func(context.Context) Node { return k })
+	} else {
+		include.setResolve(func(context.Context) Node { return nil })
+	}
+	return 1, include
+}
+
+// setResolve sets Resolve (using context.Background()) and the context aware resolve used by the builtin writers.
+func (i *Include) setResolve(resolve func(ctx context.Context) Node) {
+	i.resolve, i.Resolve = resolve, func() Node { return resolve(context.Background()) }
+}
+
+// resolveInclude returns the included src, example or export block of i - files are read using the context of d.
+func (d *Document) resolveInclude(i Include) Node {
+	if i.resolve == nil {
+		return i.Resolve()
+	}
+	return i.resolve(d.Context())
+}
+
+// writeInclude writes the included Org mode content of i - or the included src, example or export block.
+func writeInclude(w Writer, d *Document, i Include) {
+	if i.Children != nil {
+		WriteNodes(w, i.Children...)
+	} else {
+		WriteNodes(w, d.resolveInclude(i))
+	}
+}
+
+func (d *Document) parseIncludeOptions(value string) (includeOptions, error) {
+	o, m := includeOptions{}, includeFileRegexp.FindStringSubmatch(value)
+	if m == nil {
+		return o, fmt.Errorf("missing quoted file name")
+	}
+	o.path = m[1]
+	if i := strings.Index(o.path, "::"); i != -1 {
+		o.path, o.selector = o.path[:i], o.path[i+2:]
+	}
+	arguments := includeArgumentRegexp.FindAllString(m[2], -1)
+	for i := 0; i < len(arguments); i++ {
+		if !strings.HasPrefix(arguments[i], ":") {
+			if o.kind == "" {
+				o.kind = strings.ToLower(arguments[i])
+			} else if o.lang == "" {
+				o.lang = arguments[i]
+			}
+			continue
+		}
+		key, value := arguments[i], ""
+		if i+1 < len(arguments) && !strings.HasPrefix(arguments[i+1], ":") {
+			value, i = strings.Trim(arguments[i+1], `"`), i+1
+		}
+		switch key {
+		case ":lines":
+			o.lines = value
+		case ":minlevel":
+			lvl, err := strconv.Atoi(value)
+			if err != nil || lvl < 1 {
+				return o, fmt.Errorf("bad :minlevel %q", value)
+			}
+			o.minLvl = lvl
+		case ":only-contents":
+			o.onlyContents = value != "" && value != "nil"
+		}
+	}
+	if o.kind != "" && o.kind != "src" && o.kind != "example" && o.kind != "export" {
+		return o, fmt.Errorf("unsupported include kind %q", o.kind)
+	}
+	return o, nil
+}
+
+// includeOrg reads the Org mode file at path and parses its (selected) lines as part of the document.
+// The included headlines are added to the Outline of the document.
+func (d *Document) includeOrg(path string, o includeOptions) ([]Node, error) {
+	for _, includedPath := range d.includeStack {
+		if filepath.ToSlash(filepath.Clean(includedPath)) == filepath.ToSlash(filepath.Clean(path)) {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(d.includeStack, " -> "), path)
+		}
+	}
+	// the include stack is seeded with the path of the document itself (see newDocument)
+	if max := d.MaxIncludeDepth; max > 0 && len(d.includeStack)-1+d.includeDepth >= max {
+		panic(&LimitError{"include depth", max})
+	}
+	bs, err := d.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(bs), "\n"), "\n")
+	if o.selector != "" {
+		if lines, err = d.selectIncludeHeadline(lines, o.selector, o.onlyContents); err != nil {
+			return nil, err
+		}
+	}
+	if lines, err = selectIncludeLines(lines, o.lines); err != nil {
+		return nil, err
+	}
+	minLvl := o.minLvl
+	if minLvl == 0 && d.headlineLvl != 0 {
+		minLvl = d.headlineLvl + 1
+	}
+	if minLvl != 0 {
+		lines = shiftHeadlineLvls(lines, minLvl)
+	}
+
+	tokens, documentPath, baseLvl := d.tokens, d.Path, d.baseLvl
+	d.includeStack = append(d.includeStack, path)
+	defer func() {
+		d.tokens, d.Path, d.baseLvl = tokens, documentPath, baseLvl
+		d.includeStack = d.includeStack[:len(d.includeStack)-1]
+	}()
+	d.tokens, d.Path, d.baseLvl = make([]token, len(lines)), path, 0
+	for i, line := range lines {
+		d.tokens[i] = d.tokenizeLine(line)
+	}
+	_, nodes := d.parseMany(0, func(d *Document, i int) bool { return i >= len(d.tokens) })
+	return nodes, nil
+}
+
+// selectIncludeHeadline returns the subtree of the headline matching selector (*TITLE or #CUSTOM_ID).
+func (d *Document) selectIncludeHeadline(lines []string, selector string, onlyContents bool) ([]string, error) {
+	var matches func(i int) bool
+	switch {
+	case strings.HasPrefix(selector, "*"):
+		matches = func(i int) bool {
+			m := headlineRegexp.FindStringSubmatch(lines[i])
+			if m == nil {
+				return false
+			}
+			_, _, title, _ := d.splitHeadline(m[2])
+			return strings.TrimSpace(title) == strings.TrimSpace(selector[1:])
+		}
+	case strings.HasPrefix(selector, "#"):
+		matches = func(i int) bool {
+			if !headlineRegexp.MatchString(lines[i]) {
+				return false
+			}
+			for j := i + 1; j < len(lines) && !headlineRegexp.MatchString(lines[j]); j++ {
+				if m := customIDPropertyRegexp.FindStringSubmatch(lines[j]); m != nil && m[1] == selector[1:] {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		return nil, fmt.Errorf("unsupported selector %q", selector)
+	}
+	for i := range lines {
+		if !matches(i) {
+			continue
+		}
+		lvl, end := len(headlineRegexp.FindStringSubmatch(lines[i])[1]), i+1
+		for ; end < len(lines); end++ {
+			if m := headlineRegexp.FindStringSubmatch(lines[end]); m != nil && len(m[1]) <= lvl {
+				break
+			}
+		}
+		if !onlyContents {
+			return lines[i:end], nil
+		}
+		start := i + 1
+		if start < end && beginDrawerRegexp.MatchString(lines[start]) && strings.EqualFold(strings.TrimSpace(lines[start]), ":PROPERTIES:") {
+			for start < end && !endDrawerRegexp.MatchString(lines[start]) {
+				start++
+			}
+			start++
+		}
+		if start > end {
+			start = end
+		}
+		return lines[start:end], nil
+	}
+	return nil, fmt.Errorf("no headline matches selector %q", selector)
+}
+
+// selectIncludeLines returns the lines in the range FROM-TO (1-based, both optional). As in Org mode, TO is excluded.
+func selectIncludeLines(lines []string, lineRange string) ([]string, error) {
+	if lineRange == "" {
+		return lines, nil
+	}
+	m := includeLinesRegexp.FindStringSubmatch(lineRange)
+	if m == nil {
+		return nil, fmt.Errorf("bad :lines %q", lineRange)
+	}
+	from, to := 1, len(lines)
+	if m[1] != "" {
+		from, _ = strconv.Atoi(m[1])
+	}
+	if m[2] != "" {
+		to, _ = strconv.Atoi(m[2])
+		to--
+	}
+	if from < 1 {
+		from = 1
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+	if from > to {
+		return nil, nil
+	}
+	return lines[from-1 : to], nil
+}
+
+// shiftHeadlineLvls promotes / demotes all headlines so that the highest level headline has minLvl stars.
+func shiftHeadlineLvls(lines []string, minLvl int) []string {
+	currentMinLvl := 0
+	for _, line := range lines {
+		if m := headlineRegexp.FindStringSubmatch(line); m != nil && (currentMinLvl == 0 || len(m[1]) < currentMinLvl) {
+			currentMinLvl = len(m[1])
+		}
+	}
+	if currentMinLvl == 0 || currentMinLvl == minLvl {
+		return lines
+	}
+	shifted := make([]string, len(lines))
+	for i, line := range lines {
+		if m := headlineRegexp.FindStringSubmatch(line); m != nil {
+			line = strings.Repeat("*", len(m[1])-currentMinLvl+minLvl) + line[len(m[1]):]
+		}
+		shifted[i] = line
+	}
+	return shifted
+}
@@ -34,6 +34,7 @@ type InlineBlock struct {
 	Name       string
 	Parameters []string
 	Children   []Node
+	Result     []Node // Result is the cached result of inline src blocks - i.e. the content of a following {{{results(...)}}} macro.
 }
 
 type LatexFragment struct {
@@ -69,9 +70,9 @@ var timestampRegexp = regexp.MustCompile(`^<(\d{4}-\d{2}-\d{2})( [A-Za-z]+)?( \d
 var footnoteRegexp = regexp.MustCompile(`^\[fn:([\w-]*?)(:(.*?))?\]`)
 var statisticsTokenRegexp = regexp.MustCompile(`^\[(\d+/\d+|\d+%)\]`)
 var latexFragmentRegexp = regexp.MustCompile(`(?s)^\\begin{(\w+)}(.*)\\end{(\w+)}`)
-var inlineBlockRegexp = regexp.MustCompile(`src_(\w+)(\[(.*)\])?{(.*)}`)
+var inlineBlockRegexp = regexp.MustCompile(`^src_(\w+)(\[([^\]]*)\])?{`)
 var inlineExportBlockRegexp = regexp.MustCompile(`@@(\w+):(.*?)@@`)
-var macroRegexp = regexp.MustCompile(`{{{(.*)\((.*)\)}}}`)
+var macroRegexp = regexp.MustCompile(`^{{{([a-zA-Z][-\w]*)(\((.*?)\))?}}}`)
 
 var timestampFormat = "2006-01-02 Mon 15:04"
 var datestampFormat = "2006-01-02 Mon"
@@ -84,34 +85,37 @@ var latexFragmentPairs = map[string]string{
 }
 
 func (d *Document) parseInline(input string) (nodes []Node) {
+	defer d.nest()()
 	previous, current := 0, 0
 	for current < len(input) {
 		rewind, consumed, node := 0, 0, (Node)(nil)
-		switch input[current] {
-		case '^':
-			consumed, node = d.parseSubOrSuperScript(input, current)
-		case '_':
-			rewind, consumed, node = d.parseSubScriptOrEmphasisOrInlineBlock(input, current)
-		case '@':
-			consumed, node = d.parseInlineExportBlock(input, current)
-		case '*', '/', '+':
-			consumed, node = d.parseEmphasis(input, current, false)
-		case '=', '~':
-			consumed, node = d.parseEmphasis(input, current, true)
-		case '[':
-			consumed, node = d.parseOpeningBracket(input, current)
-		case '{':
-			consumed, node = d.parseMacro(input, current)
-		case '<':
-			consumed, node = d.parseTimestamp(input, current)
-		case '\\':
-			consumed, node = d.parseExplicitLineBreakOrLatexFragment(input, current)
-		case '$':
-			consumed, node = d.parseLatexFragment(input, current, 1)
-		case '\n':
-			consumed, node = d.parseLineBreak(input, current)
-		case ':':
-			rewind, consumed, node = d.parseAutoLink(input, current)
+		if consumed, node = d.parseExtensionInline(input, current); consumed == 0 {
+			switch input[current] {
+			case '^':
+				consumed, node = d.parseSubOrSuperScript(input, current)
+			case '_':
+				rewind, consumed, node = d.parseSubScriptOrEmphasisOrInlineBlock(input, current)
+			case '@':
+				consumed, node = d.parseInlineExportBlock(input, current)
+			case '*', '/', '+':
+				consumed, node = d.parseEmphasis(input, current, false)
+			case '=', '~':
+				consumed, node = d.parseEmphasis(input, current, true)
+			case '[':
+				consumed, node = d.parseOpeningBracket(input, current)
+			case '{':
+				consumed, node = d.parseMacro(input, current)
+			case '<':
+				consumed, node = d.parseTimestamp(input, current)
+			case '\\':
+				consumed, node = d.parseExplicitLineBreakOrLatexFragment(input, current)
+			case '$':
+				consumed, node = d.parseLatexFragment(input, current, 1)
+			case '\n':
+				consumed, node = d.parseLineBreak(input, current)
+			case ':':
+				rewind, consumed, node = d.parseAutoLink(input, current)
+			}
 		}
 		current -= rewind
 		if consumed != 0 {
@@ -163,18 +167,46 @@ func (d *Document) parseLineBreak(input string, start int) (int, Node) {
 }
 
 func (d *Document) parseInlineBlock(input string, start int) (int, int, Node) {
+	if rewind, consumed, node := d.parseInlineCall(input, start); consumed != 0 {
+		return rewind, consumed, node
+	}
 	if !(strings.HasSuffix(input[:start], "src") && (start-4 < 0 || unicode.IsSpace(rune(input[start-4])))) {
 		return 0, 0, nil
 	}
-	if m := inlineBlockRegexp.FindStringSubmatch(input[start-3:]); m != nil {
-		return 3, len(m[0]), InlineBlock{"src", strings.Fields(m[1] + " " + m[3]), d.parseRawInline(m[4])}
+	m := inlineBlockRegexp.FindStringSubmatch(input[start-3:])
+	if m == nil {
+		return 0, 0, nil
+	}
+	bodyStart := start - 3 + len(m[0])
+	bodyEnd := matchingBrace(input, bodyStart-1)
+	if bodyEnd == -1 {
+		return 0, 0, nil
+	}
+	consumed, result := d.parseInlineResult(input, bodyEnd+1)
+	return 3, bodyEnd + 1 - (start - 3) + consumed, InlineBlock{Name: "src", Parameters: strings.Fields(m[1] + " " + m[3]), Children: d.parseRawInline(input[bodyStart:bodyEnd]), Result: result}
+}
+
+// matchingBrace returns the index of the brace closing the brace at input[start] - or -1 if it is not closed on the same line.
+func matchingBrace(input string, start int) int {
+	depth := 0
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		case '\n':
+			return -1
+		}
 	}
-	return 0, 0, nil
+	return -1
 }
 
 func (d *Document) parseInlineExportBlock(input string, start int) (int, Node) {
 	if m := inlineExportBlockRegexp.FindStringSubmatch(input[start:]); m != nil {
-		return len(m[0]), InlineBlock{"export", m[1:2], d.parseRawInline(m[2])}
+		return len(m[0]), InlineBlock{Name: "export", Parameters: m[1:2], Children: d.parseRawInline(m[2])}
 	}
 	return 0, nil
 }
@@ -240,6 +272,8 @@ func (d *Document) parseOpeningBracket(input string, start int) (int, Node) {
 		return d.parseRegularLink(input, start)
 	} else if footnoteRegexp.MatchString(input[start:]) {
 		return d.parseFootnoteReference(input, start)
+	} else if citationRegexp.MatchString(input[start:]) {
+		return d.parseCitation(input, start)
 	} else if statisticsTokenRegexp.MatchString(input[start:]) {
 		return d.parseStatisticToken(input, start)
 	}
@@ -248,7 +282,10 @@ func (d *Document) parseOpeningBracket(input string, start int) (int, Node) {
 
 func (d *Document) parseMacro(input string, start int) (int, Node) {
 	if m := macroRegexp.FindStringSubmatch(input[start:]); m != nil {
-		return len(m[0]), Macro{m[1], strings.Split(m[2], ",")}
+		if m[2] == "" {
+			return len(m[0]), Macro{m[1], nil}
+		}
+		return len(m[0]), Macro{m[1], splitMacroArguments(m[3])}
 	}
 	return 0, nil
 }
@@ -403,14 +440,14 @@ func (l RegularLink) Kind() string {
 	return "regular"
 }
 
-func (n Text) String() string              { return orgWriter.WriteNodesAsString(n) }
-func (n LineBreak) String() string         { return orgWriter.WriteNodesAsString(n) }
-func (n ExplicitLineBreak) String() string { return orgWriter.WriteNodesAsString(n) }
-func (n StatisticToken) String() string    { return orgWriter.WriteNodesAsString(n) }
-func (n Emphasis) String() string          { return orgWriter.WriteNodesAsString(n) }
-func (n InlineBlock) String() string       { return orgWriter.WriteNodesAsString(n) }
-func (n LatexFragment) String() string     { return orgWriter.WriteNodesAsString(n) }
-func (n FootnoteLink) String() string      { return orgWriter.WriteNodesAsString(n) }
-func (n RegularLink) String() string       { return orgWriter.WriteNodesAsString(n) }
-func (n Macro) String() string             { return orgWriter.WriteNodesAsString(n) }
-func (n Timestamp) String() string         { return orgWriter.WriteNodesAsString(n) }
+func (n Text) String() string              { return NewOrgWriter().WriteNodesAsString(n) }
+func (n LineBreak) String() string         { return NewOrgWriter().WriteNodesAsString(n) }
+func (n ExplicitLineBreak) String() string { return NewOrgWriter().WriteNodesAsString(n) }
+func (n StatisticToken) String() string    { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Emphasis) String() string          { return NewOrgWriter().WriteNodesAsString(n) }
+func (n InlineBlock) String() string       { return NewOrgWriter().WriteNodesAsString(n) }
+func (n LatexFragment) String() string     { return NewOrgWriter().WriteNodesAsString(n) }
+func (n FootnoteLink) String() string      { return NewOrgWriter().WriteNodesAsString(n) }
+func (n RegularLink) String() string       { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Macro) String() string             { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Timestamp) String() string         { return NewOrgWriter().WriteNodesAsString(n) }
@@ -2,7 +2,7 @@ package org
 
 import (
 	"bytes"
-	"path/filepath"
+	"context"
 	"regexp"
 	"strings"
 )
@@ -27,17 +27,21 @@ type NodeWithMeta struct {
 type Metadata struct {
 	Caption        [][]Node
 	HTMLAttributes [][]string
+	Header         [][]string // Header contains the parameters of #+HEADER lines (i.e. header arguments for src blocks).
+	Number         int        // Number is the number of the element among the exported captioned elements of the same kind (see Captioned).
+	captioned      *Captioned
 }
 
 type Include struct {
 	Keyword
-	Resolve func() Node
+	Resolve  func() Node // Resolve returns the included src, example or export block.
+	Children []Node      // Children contains the parsed nodes of included Org mode content (see Resolve for other kinds).
+	resolve  func(ctx context.Context) Node
 }
 
 var keywordRegexp = regexp.MustCompile(`^(\s*)#\+([^:]+):(\s+(.*)|$)`)
 var commentRegexp = regexp.MustCompile(`^(\s*)#\s(.*)`)
 
-var includeFileRegexp = regexp.MustCompile(`(?i)^"([^"]+)" (src|example|export) (\w+)$`)
 var attributeRegexp = regexp.MustCompile(`(?:^|\s+)(:[-\w]+)\s+(.*)$`)
 
 func lexKeywordOrComment(line string) (token, bool) {
@@ -59,20 +63,25 @@ func (d *Document) parseKeyword(i int, stop stopFn) (int, Node) {
 	case "NAME":
 		return d.parseNodeWithName(k, i, stop)
 	case "SETUPFILE":
-		return d.loadSetupFile(k)
+		return d.loadSetupFile(k, i)
 	case "INCLUDE":
-		return d.parseInclude(k)
+		return d.parseInclude(k, i)
+	case "CALL":
+		return d.parseCall(k, i, stop)
+	case "BIBLIOGRAPHY":
+		d.loadBibliography(k, i)
+		return 1, k
 	case "LINK":
 		if parts := strings.Split(k.Value, " "); len(parts) >= 2 {
-			d.Links[parts[0]] = parts[1]
+			d.setString(d.Links, parts[0], parts[1])
 		}
 		return 1, k
 	case "MACRO":
-		if parts := strings.Split(k.Value, " "); len(parts) >= 2 {
-			d.Macros[parts[0]] = parts[1]
+		if parts := strings.SplitN(k.Value, " ", 2); len(parts) == 2 {
+			d.setString(d.Macros, parts[0], strings.TrimSpace(parts[1]))
 		}
 		return 1, k
-	case "CAPTION", "ATTR_HTML":
+	case "CAPTION", "ATTR_HTML", "HEADER":
 		consumed, node := d.parseAffiliated(i, stop)
 		if consumed != 0 {
 			return consumed, node
@@ -80,9 +89,9 @@ func (d *Document) parseKeyword(i int, stop stopFn) (int, Node) {
 		fallthrough
 	default:
 		if _, ok := d.BufferSettings[k.Key]; ok {
-			d.BufferSettings[k.Key] = strings.Join([]string{d.BufferSettings[k.Key], k.Value}, "\n")
+			d.setString(d.BufferSettings, k.Key, strings.Join([]string{d.BufferSettings[k.Key], k.Value}, "\n"))
 		} else {
-			d.BufferSettings[k.Key] = k.Value
+			d.setString(d.BufferSettings, k.Key, k.Value)
 		}
 		return 1, k
 	}
@@ -96,14 +105,31 @@ func (d *Document) parseNodeWithName(k Keyword, i int, stop stopFn) (int, Node)
 	if consumed == 0 || node == nil {
 		return 0, nil
 	}
-	d.NamedNodes[k.Value] = node
-	return consumed + 1, NodeWithName{k.Value, node}
+	return consumed + 1, d.nameNode(k.Value, node)
+}
+
+func (d *Document) nameNode(name string, node Node) NodeWithName {
+	old, ok := d.NamedNodes[name]
+	d.journal(func() {
+		if ok {
+			d.NamedNodes[name] = old
+		} else {
+			delete(d.NamedNodes, name)
+		}
+	})
+	d.NamedNodes[name] = node
+	if n, ok := node.(NodeWithMeta); ok && n.Meta.captioned != nil {
+		n.Meta.captioned.Name = name
+	}
+	return NodeWithName{name, node}
 }
 
 func (d *Document) parseAffiliated(i int, stop stopFn) (int, Node) {
-	start, meta := i, Metadata{}
+	start, meta, name := i, Metadata{}, ""
 	for ; !stop(d, i) && d.tokens[i].kind == "keyword"; i++ {
 		switch k := parseKeyword(d.tokens[i]); k.Key {
+		case "NAME":
+			name = k.Value
 		case "CAPTION":
 			meta.Caption = append(meta.Caption, d.parseInline(k.Value))
 		case "ATTR_HTML":
@@ -124,6 +150,8 @@ func (d *Document) parseAffiliated(i int, stop stopFn) (int, Node) {
 				}
 			}
 			meta.HTMLAttributes = append(meta.HTMLAttributes, attributes)
+		case "HEADER":
+			meta.Header = append(meta.Header, splitParameters(" "+k.Value))
 		default:
 			return 0, nil
 		}
@@ -135,8 +163,22 @@ func (d *Document) parseAffiliated(i int, stop stopFn) (int, Node) {
 	if consumed == 0 || node == nil {
 		return 0, nil
 	}
+	if b, ok := node.(Block); ok && b.HeaderArgs != nil {
+		for k := len(meta.Header) - 1; k >= 0; k-- {
+			for parameters, j := meta.Header[k], 0; j+1 < len(parameters); j += 2 {
+				if _, ok := b.HeaderArgs[parameters[j]]; !ok {
+					b.HeaderArgs[parameters[j]] = parameters[j+1]
+				}
+			}
+		}
+	}
 	i += consumed
-	return i - start, NodeWithMeta{node, meta}
+	n := d.addCaptioned(NodeWithMeta{node, meta})
+	if name != "" {
+		// #+NAME: after #+CAPTION: - e.g. #+CAPTION: ...\n#+NAME: ...
+		return i - start, d.nameNode(name, n)
+	}
+	return i - start, n
 }
 
 func parseKeyword(t token) Keyword {
@@ -144,51 +186,32 @@ func parseKeyword(t token) Keyword {
 	return Keyword{strings.ToUpper(k), strings.TrimSpace(v)}
 }
 
-func (d *Document) parseInclude(k Keyword) (int, Node) {
-	resolve := func() Node {
-		d.Log.Printf("Bad include %#v", k)
-		return k
-	}
-	if m := includeFileRegexp.FindStringSubmatch(k.Value); m != nil {
-		path, kind, lang := m[1], m[2], m[3]
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(filepath.Dir(d.Path), path)
-		}
-		resolve = func() Node {
-			bs, err := d.ReadFile(path)
-			if err != nil {
-				d.Log.Printf("Bad include %#v: %s", k, err)
-				return k
-			}
-			return Block{strings.ToUpper(kind), []string{lang}, d.parseRawInline(string(bs)), nil}
-		}
-	}
-	return 1, Include{k, resolve}
-}
-
-func (d *Document) loadSetupFile(k Keyword) (int, Node) {
-	path := k.Value
-	if !filepath.IsAbs(path) {
-		path = filepath.Join(filepath.Dir(d.Path), path)
+func (d *Document) loadSetupFile(k Keyword, i int) (int, Node) {
+	path, err := d.resolvePath(k.Value)
+	if err != nil {
+		d.Log.Printf("Bad setup file: %#v: %s", k, err)
+		d.addFileDiagnostic(d.line(i), k, err)
+		return 1, k
 	}
-	bs, err := d.ReadFile(path)
+	bs, err := d.readFile(path)
 	if err != nil {
 		d.Log.Printf("Bad setup file: %#v: %s", k, err)
+		d.addFileDiagnostic(d.line(i), k, err)
 		return 1, k
 	}
-	setupDocument := d.Configuration.Parse(bytes.NewReader(bs), path)
+	setupDocument := d.parseFile(bytes.NewReader(bs), path)
 	if err := setupDocument.Error; err != nil {
 		d.Log.Printf("Bad setup file: %#v: %s", k, err)
 		return 1, k
 	}
 	for k, v := range setupDocument.BufferSettings {
-		d.BufferSettings[k] = v
+		d.setString(d.BufferSettings, k, v)
 	}
 	return 1, k
 }
 
-func (n Comment) String() string      { return orgWriter.WriteNodesAsString(n) }
-func (n Keyword) String() string      { return orgWriter.WriteNodesAsString(n) }
-func (n NodeWithMeta) String() string { return orgWriter.WriteNodesAsString(n) }
-func (n NodeWithName) String() string { return orgWriter.WriteNodesAsString(n) }
-func (n Include) String() string      { return orgWriter.WriteNodesAsString(n) }
+func (n Comment) String() string      { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Keyword) String() string      { return NewOrgWriter().WriteNodesAsString(n) }
+func (n NodeWithMeta) String() string { return NewOrgWriter().WriteNodesAsString(n) }
+func (n NodeWithName) String() string { return NewOrgWriter().WriteNodesAsString(n) }
+func (n Include) String() string      { return NewOrgWriter().WriteNodesAsString(n) }
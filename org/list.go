@@ -98,7 +98,7 @@ func (d *Document) parseListItem(l List, i int, parentStop stopFn) (int, Node) {
 		}
 	}
 
-	d.tokens[i] = tokenize(strings.Repeat(" ", minIndent) + content)
+	d.setToken(i, d.tokenizeLine(strings.Repeat(" ", minIndent)+content))
 	stop := func(d *Document, i int) bool {
 		if parentStop(d, i) {
 			return true
@@ -118,6 +118,6 @@ func (d *Document) parseListItem(l List, i int, parentStop stopFn) (int, Node) {
 	return i - start, ListItem{bullet, status, value, nodes}
 }
 
-func (n List) String() string                { return orgWriter.WriteNodesAsString(n) }
-func (n ListItem) String() string            { return orgWriter.WriteNodesAsString(n) }
-func (n DescriptiveListItem) String() string { return orgWriter.WriteNodesAsString(n) }
+func (n List) String() string                { return NewOrgWriter().WriteNodesAsString(n) }
+func (n ListItem) String() string            { return NewOrgWriter().WriteNodesAsString(n) }
+func (n DescriptiveListItem) String() string { return NewOrgWriter().WriteNodesAsString(n) }
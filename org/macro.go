@@ -0,0 +1,212 @@
+package org
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var macroArgumentRegexp = regexp.MustCompile(`\$(\d+)`)
+var macroArgumentSeparatorRegexp = regexp.MustCompile(`(\\*),`)
+var strftimeRegexp = regexp.MustCompile(`%.`)
+
+// now is used by the {{{time(FORMAT)}}} macro.
+var now = time.Now
+
+var strftimeFormats = map[string]string{
+	"%Y": "2006",
+	"%y": "06",
+	"%m": "01",
+	"%d": "02",
+	"%e": "_2",
+	"%H": "15",
+	"%I": "03",
+	"%M": "04",
+	"%S": "05",
+	"%p": "PM",
+	"%A": "Monday",
+	"%a": "Mon",
+	"%B": "January",
+	"%b": "Jan",
+	"%h": "Jan",
+	"%Z": "MST",
+	"%z": "-0700",
+	"%F": "2006-01-02",
+	"%T": "15:04:05",
+	"%R": "15:04",
+	"%D": "01/02/06",
+}
+
+// splitMacroArguments splits the arguments of a macro call on commas. Commas can be escaped with a backslash.
+func splitMacroArguments(s string) []string {
+	arguments, current := []string{}, ""
+	for {
+		m := macroArgumentSeparatorRegexp.FindStringSubmatchIndex(s)
+		if m == nil {
+			break
+		}
+		backslashes := m[3] - m[2]
+		current += s[:m[2]] + strings.Repeat(`\`, backslashes/2)
+		if backslashes%2 == 1 {
+			current += ","
+		} else {
+			arguments = append(arguments, strings.TrimSpace(current))
+			current = ""
+		}
+		s = s[m[1]:]
+	}
+	return append(arguments, strings.TrimSpace(current+s))
+}
+
+// escapeMacroArgument is the inverse of splitMacroArguments for a single argument.
+func escapeMacroArgument(s string) string {
+	return macroArgumentSeparatorRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		return strings.Repeat(`\`, 2*(len(m)-1)) + `\,`
+	})
+}
+
+// ExpandMacro returns the Org mode text that m expands to.
+// Macros defined via #+MACRO take precedence over the builtin macros (title, author, email, date, time, property,
+// keyword, input-file, modification-time, n and results). h is the headline containing the macro and is used for property lookups.
+func (d *Document) ExpandMacro(m Macro, h *Headline) (string, bool) {
+	if template, ok := d.Macros[m.Name]; ok {
+		return macroArgumentRegexp.ReplaceAllStringFunc(template, func(s string) string {
+			i, _ := strconv.Atoi(s[1:])
+			if i == 0 {
+				return strings.Join(m.Parameters, ",")
+			} else if i <= len(m.Parameters) {
+				return m.Parameters[i-1]
+			}
+			return ""
+		}), true
+	}
+	argument := func(i int) string {
+		if i < len(m.Parameters) {
+			return m.Parameters[i]
+		}
+		return ""
+	}
+	switch m.Name {
+	case "title", "author", "email":
+		return d.Get(strings.ToUpper(m.Name)), true
+	case "keyword":
+		return d.Get(strings.ToUpper(argument(0))), true
+	case "date":
+		date := d.Get("DATE")
+		if t, ok := parseDate(date); ok && argument(0) != "" {
+			return strftime(t, argument(0)), true
+		}
+		return date, true
+	case "time":
+		return strftime(now(), argument(0)), true
+	case "property":
+		if h == nil {
+			return "", true
+		}
+		v, _ := h.Properties.Get(strings.ToUpper(argument(0)))
+		return v, true
+	case "input-file":
+		return filepath.Base(d.Path), true
+	case "modification-time":
+		info, err := d.statFile(d.Path)
+		if err != nil {
+			d.Log.Printf("Bad modification-time macro: %s", err)
+			return "", true
+		}
+		return strftime(info.ModTime(), argument(0)), true
+	case "n":
+		return strconv.Itoa(d.incrementMacroCounter(argument(0), argument(1))), true
+	case "results":
+		return argument(0), true
+	}
+	return "", false
+}
+
+// incrementMacroCounter implements {{{n(NAME,ACTION)}}}. ACTION - holds the counter, a number sets it
+// and any other non-empty string resets it to 1.
+func (d *Document) incrementMacroCounter(name, action string) int {
+	write := d.writing()
+	if write.macroCounters == nil {
+		write.macroCounters = map[string]int{}
+	}
+	if action == "-" {
+		return write.macroCounters[name]
+	} else if i, err := strconv.Atoi(action); err == nil {
+		write.macroCounters[name] = i
+	} else if action != "" {
+		write.macroCounters[name] = 1
+	} else {
+		write.macroCounters[name]++
+	}
+	return write.macroCounters[name]
+}
+
+// writeMacro expands m, parses the expansion and writes the resulting nodes using w.
+func (d *Document) writeMacro(w Writer, m Macro, h *Headline) {
+	macro, ok := d.ExpandMacro(m, h)
+	if !ok {
+		d.Log.Printf("Undefined macro: %s", m.Name)
+		return
+	}
+	write := d.writing()
+	if max := d.MaxMacroDepth; max > 0 && write.macroDepth >= max {
+		panic(&LimitError{"macro depth", max})
+	}
+	write.macroDepth++
+	defer func() { write.macroDepth-- }()
+	macroDocument := d.parseSubDocument(macro)
+	if macroDocument.Error != nil {
+		d.Log.Printf("bad macro: %s -> %s: %v", m.Name, macro, macroDocument.Error)
+	}
+	nodes := macroDocument.Nodes
+	if len(nodes) == 1 {
+		if p, ok := nodes[0].(Paragraph); ok {
+			nodes = p.Children
+		}
+	}
+	WriteNodes(w, nodes...)
+}
+
+func (d *Document) statFile(path string) (os.FileInfo, error) {
+	if d.FS != nil {
+		return fs.Stat(d.FS, filepath.ToSlash(path))
+	}
+	return os.Stat(path)
+}
+
+func parseDate(s string) (time.Time, bool) {
+	if m := timestampRegexp.FindStringSubmatch(s); m != nil {
+		ddmmyy, hhmm := m[1], strings.TrimSpace(m[3])
+		if hhmm == "" {
+			hhmm = "00:00"
+		}
+		t, err := time.Parse("2006-01-02 15:04", ddmmyy+" "+hhmm)
+		return t, err == nil
+	}
+	t, err := time.Parse("2006-01-02", strings.TrimSpace(s))
+	return t, err == nil
+}
+
+// strftime formats t using the format-time-string directives used by Org mode (e.g. "%Y-%m-%d").
+func strftime(t time.Time, format string) string {
+	if format == "" {
+		format = "%Y-%m-%d %a %H:%M"
+	}
+	return strftimeRegexp.ReplaceAllStringFunc(format, func(directive string) string {
+		switch directive {
+		case "%%":
+			return "%"
+		case "%j":
+			return fmt.Sprintf("%03d", t.YearDay())
+		}
+		if layout, ok := strftimeFormats[directive]; ok {
+			return t.Format(layout)
+		}
+		return directive
+	})
+}
@@ -0,0 +1,488 @@
+package org
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ManWriter exports an org document into a man(7) page (see ox-man).
+//
+// The title and section of the page are taken from #+TITLE (falling back to the name of the file)
+// and #+MAN_SECTION (defaults to 1) - top-level headlines become sections (.SH), all others subsections (.SS).
+type ManWriter struct {
+	ExtendingWriter Writer
+	NodeWriters     map[reflect.Type]NodeWriteFn // NodeWriters write custom nodes (see Extensions) - keyed by the type of the node.
+
+	strings.Builder
+	document       *Document
+	headline       *Headline
+	inline         bool
+	listDepth      int
+	itemStart      bool
+	fonts          []string
+	sectionNumbers map[int]string
+	footnoteNumbers
+}
+
+var manEmphasisFonts = map[string]string{
+	"*": "B",
+	"/": "I",
+	"_": "I",
+	"~": "B",
+	"=": "B",
+	"+": "",
+}
+
+// manLineBreak marks the lines of explicit line breaks until they are written as .br requests (see writeLines).
+// NUL characters are removed from text by manEscaper so that it can only be written by WriteExplicitLineBreak.
+const manLineBreak = "\x00br"
+
+var manEscaper = strings.NewReplacer(`\`, `\e`, "-", `\-`, "\x00", "")
+
+func NewManWriter() *ManWriter {
+	return &ManWriter{}
+}
+
+func (w *ManWriter) WriterWithExtensions() Writer {
+	if w.ExtendingWriter != nil {
+		return w.ExtendingWriter
+	}
+	return w
+}
+
+func (w *ManWriter) WriteCustomNode(n Node) {
+	writeCustomNode(w.WriterWithExtensions(), w.NodeWriters, n)
+}
+
+func (w *ManWriter) writtenDocument() *Document { return w.document }
+func (w *ManWriter) builder() *strings.Builder  { return &w.Builder }
+
+func (w *ManWriter) Before(d *Document) {
+	w.document, w.footnoteNumbers = d, footnoteNumbers{}
+	w.sectionNumbers = d.sectionNumbers()
+	title := d.Get("TITLE")
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(d.Path), filepath.Ext(d.Path))
+	}
+	section := d.Get("MAN_SECTION")
+	if section == "" {
+		section = "1"
+	}
+	th := fmt.Sprintf(".TH %s %s", manArgument(manEscaper.Replace(strings.ToUpper(title))), manArgument(manEscaper.Replace(section)))
+	if date := d.Get("DATE"); date != "" {
+		th += " " + manArgument(manEscaper.Replace(date))
+	}
+	w.WriteString(th + "\n")
+}
+
+func (w *ManWriter) After(d *Document) {
+	if d.GetOption("f") != "nil" && len(w.footnotes) != 0 {
+		w.writeMacro(`.SH "NOTES"`)
+		for i := 0; i < len(w.footnotes); i++ { // footnote definitions can reference further footnotes
+			if f := w.footnotes[i]; f == nil {
+				d.Log.Printf("Missing footnote definition for footnote #%d", i+1)
+			} else {
+				w.writeItem(fmt.Sprintf(".IP [%d] 4", i+1), f.Children)
+			}
+		}
+	}
+}
+
+func (w *ManWriter) WriteNodesAsString(nodes ...Node) string {
+	builder := w.Builder
+	w.Builder = strings.Builder{}
+	defer func() { w.Builder = builder }() // restored on panics as well - see writeNode
+	WriteNodes(w, nodes...)
+	return w.String()
+}
+
+// writeInline returns the inline nodes as a single line - (soft) line breaks are replaced by spaces.
+func (w *ManWriter) writeInline(nodes ...Node) string {
+	inline := w.inline
+	w.inline = true
+	out := w.WriteNodesAsString(nodes...)
+	w.inline = inline
+	return out
+}
+
+// escape escapes s as roff text (see plainWriter).
+func (w *ManWriter) escape(s string) string { return manEscaper.Replace(s) }
+
+// writeMacro writes a request (e.g. .SH) on a line of its own.
+func (w *ManWriter) writeMacro(macro string) {
+	if out := w.String(); out != "" && !strings.HasSuffix(out, "\n") {
+		w.WriteString("\n")
+	}
+	w.WriteString(macro + "\n")
+}
+
+// startParagraph starts a new paragraph - or continues the current list item.
+// Paragraphs inside list items keep the indentation of the item.
+func (w *ManWriter) startParagraph() {
+	if w.itemStart {
+		w.itemStart = false
+	} else if w.listDepth > 0 {
+		w.writeMacro(".IP")
+	} else {
+		w.writeMacro(".PP")
+	}
+}
+
+// writeLines writes the lines of s as text. Lines starting with a control character (. or ') are escaped -
+// the lines marking explicit line breaks (see manLineBreak) are written as .br requests.
+// Filled text is stripped of empty lines and leading whitespace as roff treats those as breaks.
+func (w *ManWriter) writeLines(s string, fill bool) {
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		if fill {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+		}
+		if line == manLineBreak {
+			line = ".br"
+		} else if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			line = `\&` + line
+		}
+		w.writeMacro(line)
+	}
+}
+
+// writeVerbatim writes s without filling (.nf) - indented by indent (.RS) unless indent is empty.
+func (w *ManWriter) writeVerbatim(s, indent string) {
+	w.startParagraph()
+	if indent != "" {
+		w.writeMacro(".RS " + indent)
+	}
+	w.writeMacro(".nf")
+	w.writeLines(s, false)
+	w.writeMacro(".fi")
+	if indent != "" {
+		w.writeMacro(".RE")
+	}
+}
+
+// writeItem writes nodes as the body of a list item (or footnote) started by the tagged paragraph macro.
+func (w *ManWriter) writeItem(macro string, nodes []Node) {
+	w.writeMacro(macro)
+	itemStart := w.itemStart
+	w.itemStart = true
+	WriteNodes(w, nodes...)
+	w.itemStart = itemStart
+}
+
+// manArgument quotes s as a single argument of a request.
+func manArgument(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\(dq`) + `"`
+}
+
+func (w *ManWriter) WriteHeadline(h Headline) {
+	if h.IsExcluded(w.document) {
+		return
+	}
+	title := ""
+	if number, ok := w.sectionNumbers[h.Index]; ok {
+		title += number + " "
+	}
+	if w.document.GetOption("todo") != "nil" && h.Status != "" {
+		title += h.Status + " "
+	}
+	if w.document.GetOption("pri") != "nil" && h.Priority != "" {
+		title += "[#" + h.Priority + "] "
+	}
+	if w.document.isListHeadline(h) {
+		parentHeadline := w.headline
+		w.headline = &h
+		item := ListItem{Bullet: "-", Children: append([]Node{Paragraph{append([]Node{Text{title, true}}, h.Title...)}}, h.Children...)}
+		w.WriteList(List{Kind: "unordered", Items: []Node{item}})
+		w.headline = parentHeadline
+		return
+	}
+	title += w.writeInline(h.Title...)
+	if h.Lvl == 1 {
+		w.writeMacro(".SH " + manArgument(title))
+	} else {
+		w.writeMacro(".SS " + manArgument(title))
+	}
+	parentHeadline, listDepth, itemStart := w.headline, w.listDepth, w.itemStart
+	w.headline, w.listDepth, w.itemStart = &h, 0, false
+	WriteNodes(w, h.Children...)
+	w.headline, w.listDepth, w.itemStart = parentHeadline, listDepth, itemStart
+}
+
+func (w *ManWriter) WriteBlock(b Block) {
+	params := b.HeaderArgs
+	if params == nil {
+		params = b.ParameterMap()
+	}
+	switch b.Name {
+	case "SRC", "EXAMPLE":
+		if params[":exports"] != "results" && params[":exports"] != "none" {
+			w.writeVerbatim(w.WriteNodesAsString(b.Children...), "4")
+		}
+	case "EXPORT":
+		if len(b.Parameters) >= 1 && strings.ToLower(b.Parameters[0]) == "man" {
+			w.writeMacro(strings.TrimRight(String(b.Children), "\n"))
+		}
+	case "VERSE":
+		w.writeVerbatim(w.WriteNodesAsString(b.Children...), "")
+	case "CENTER":
+		w.writeMacro(".ce 1000")
+		WriteNodes(w, b.Children...)
+		w.writeMacro(".ce 0")
+	case "QUOTE":
+		w.writeMacro(".RS")
+		WriteNodes(w, b.Children...)
+		if len(b.Parameters) != 0 {
+			w.startParagraph()
+			w.writeLines(`\(em `+manEscaper.Replace(strings.Join(b.Parameters, " ")), true)
+		}
+		w.writeMacro(".RE")
+	default:
+		WriteNodes(w, b.Children...)
+	}
+	if b.Result != nil && params[":exports"] != "code" && params[":exports"] != "none" {
+		WriteNodes(w, b.Result)
+	}
+}
+
+func (w *ManWriter) WriteResult(r Result) { WriteNodes(w, r.Node) }
+
+func (w *ManWriter) WriteInlineBlock(b InlineBlock) {
+	switch b.Name {
+	case "src":
+		exports := Block{Parameters: b.Parameters}.ParameterMap()[":exports"]
+		if exports != "results" && exports != "none" {
+			w.writeFont("B", b.Children)
+		}
+		if exports == "results" || exports == "both" {
+			WriteNodes(w, b.Result...)
+		}
+	case "export":
+		if strings.ToLower(b.Parameters[0]) == "man" {
+			w.WriteString(String(b.Children))
+		}
+	}
+}
+
+func (w *ManWriter) WriteCall(c Call) { writeCall(w, w.document, c) }
+
+func (w *ManWriter) WriteInlineCall(c InlineCall) { writeInlineCall(w, w.document, c) }
+
+func (w *ManWriter) WriteDrawer(d Drawer) { WriteNodes(w, d.Children...) }
+
+func (w *ManWriter) WritePropertyDrawer(PropertyDrawer) {}
+
+func (w *ManWriter) WriteComment(Comment) {}
+
+func (w *ManWriter) WriteKeyword(k Keyword) {
+	switch k.Key {
+	case "MAN":
+		w.writeMacro(k.Value)
+	case "PRINT_BIBLIOGRAPHY":
+		for _, e := range w.document.formatBibliography(manCitationMarkup) {
+			w.startParagraph()
+			w.writeLines(e[1], true)
+		}
+	}
+}
+
+var manCitationMarkup = citationMarkup{
+	escape:   manEscaper.Replace,
+	emphasis: func(s string) string { return `\fI` + s + `\fR` },
+	link: func(url, text string) string {
+		if strings.HasPrefix(url, "#") || url == text {
+			return text
+		}
+		return text + " <" + manEscaper.Replace(url) + ">"
+	},
+}
+
+func (w *ManWriter) WriteInclude(i Include) { writeInclude(w, w.document, i) }
+
+func (w *ManWriter) WriteNodeWithMeta(n NodeWithMeta) {
+	if len(n.Meta.Caption) != 0 {
+		w.startParagraph()
+		w.writeLines(plainCaption(w, n, `\fB%s %d:\fR `), true)
+	}
+	WriteNodes(w, n.Node)
+}
+
+func (w *ManWriter) WriteNodeWithName(n NodeWithName) { WriteNodes(w, n.Node) }
+
+func (w *ManWriter) WriteFootnoteDefinition(FootnoteDefinition) {}
+
+func (w *ManWriter) WriteParagraph(p Paragraph) {
+	content := w.WriteNodesAsString(p.Children...)
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	w.startParagraph()
+	w.writeLines(content, true)
+}
+
+func (w *ManWriter) WriteExample(e Example) {
+	lines := []string{}
+	for _, n := range e.Children {
+		lines = append(lines, w.WriteNodesAsString(n))
+	}
+	w.writeVerbatim(strings.Join(lines, "\n"), "4")
+}
+
+func (w *ManWriter) WriteList(l List) {
+	if w.listDepth > 0 {
+		w.writeMacro(".RS")
+	}
+	w.listDepth++
+	WriteNodes(w, l.Items...)
+	w.listDepth--
+	if w.listDepth > 0 {
+		w.writeMacro(".RE")
+	}
+}
+
+func (w *ManWriter) WriteListItem(li ListItem) {
+	w.writeItem(w.listItemMacro(li.Bullet, li.Status), li.Children)
+}
+
+func (w *ManWriter) WriteDescriptiveListItem(di DescriptiveListItem) {
+	w.writeMacro(".TP")
+	w.writeLines(`\fB`+w.writeInline(di.Term...)+`\fR`, true)
+	itemStart := w.itemStart
+	w.itemStart = true
+	WriteNodes(w, di.Details...)
+	w.itemStart = itemStart
+}
+
+// listItemMacro returns the .IP request for a list item - the bullet is the tag and the body is indented past it.
+func (w *ManWriter) listItemMacro(bullet, status string) string {
+	tag, width := manEscaper.Replace(bullet), utf8.RuneCountInString(bullet)
+	if bullet == "-" || bullet == "+" || bullet == "*" {
+		tag = `\(bu`
+	}
+	if status != "" {
+		tag, width = tag+" ["+status+"]", width+4
+	}
+	if width += 2; width < 4 {
+		width = 4
+	}
+	return ".IP " + manArgument(tag) + " " + strconv.Itoa(width)
+}
+
+// WriteTable writes the table as preformatted text - drawn like the ascii TextWriter does.
+func (w *ManWriter) WriteTable(t Table) {
+	tw := NewTextWriter()
+	tw.ASCII, tw.Width, tw.document, tw.footnoteNumbers = true, 0, w.document, w.footnoteNumbers
+	tw.WriteTable(t)
+	w.footnoteNumbers = tw.footnoteNumbers // footnotes referenced in the cells are written by w (see After)
+	w.writeVerbatim(manEscaper.Replace(tw.String()), "")
+}
+
+func (w *ManWriter) WriteHorizontalRule(HorizontalRule) {
+	w.startParagraph()
+	w.writeMacro(`\l'\n(.lu'`)
+}
+
+func (w *ManWriter) WriteText(t Text) {
+	if t.IsRaw || w.document.GetOption("e") == "nil" {
+		w.WriteString(manEscaper.Replace(t.Content))
+	} else {
+		w.WriteString(manEscaper.Replace(htmlEntityReplacer.Replace(t.Content)))
+	}
+}
+
+func (w *ManWriter) WriteEmphasis(e Emphasis) {
+	switch e.Kind {
+	case "_{}":
+		w.WriteString("_")
+		WriteNodes(w, e.Content...)
+	case "^{}":
+		w.WriteString("^")
+		WriteNodes(w, e.Content...)
+	default:
+		font, ok := manEmphasisFonts[e.Kind]
+		if !ok {
+			panic(fmt.Sprintf("bad emphasis %#v", e))
+		}
+		w.writeFont(font, e.Content)
+	}
+}
+
+// writeFont writes nodes in font (e.g. B for bold) and switches back to the enclosing font afterwards.
+// \fP can't be used for that as it only remembers a single previous font.
+func (w *ManWriter) writeFont(font string, nodes []Node) {
+	if font == "" {
+		WriteNodes(w, nodes...)
+		return
+	}
+	w.fonts = append(w.fonts, font)
+	w.WriteString(`\f` + font)
+	WriteNodes(w, nodes...)
+	w.fonts = w.fonts[:len(w.fonts)-1]
+	if len(w.fonts) == 0 {
+		w.WriteString(`\fR`)
+	} else {
+		w.WriteString(`\f` + w.fonts[len(w.fonts)-1])
+	}
+}
+
+func (w *ManWriter) WriteLatexFragment(l LatexFragment) {
+	w.WriteString(manEscaper.Replace(l.OpeningPair))
+	WriteNodes(w, l.Content...)
+	w.WriteString(manEscaper.Replace(l.ClosingPair))
+}
+
+func (w *ManWriter) WriteStatisticToken(s StatisticToken) {
+	w.WriteString(fmt.Sprintf("[%s]", s.Content))
+}
+
+func (w *ManWriter) WriteLineBreak(l LineBreak) {
+	if w.inline {
+		w.WriteString(" ")
+	} else {
+		w.WriteString(strings.Repeat("\n", l.Count))
+	}
+}
+
+func (w *ManWriter) WriteExplicitLineBreak(ExplicitLineBreak) {
+	if w.inline {
+		w.WriteString(" ")
+	} else {
+		w.WriteString("\n" + manLineBreak + "\n")
+	}
+}
+
+func (w *ManWriter) WriteTimestamp(t Timestamp) {
+	if w.document.GetOption("<") == "nil" {
+		return
+	}
+	w.WriteString("<")
+	if t.IsDate {
+		w.WriteString(manEscaper.Replace(t.Time.Format(datestampFormat)))
+	} else {
+		w.WriteString(manEscaper.Replace(t.Time.Format(timestampFormat)))
+	}
+	if t.Interval != "" {
+		w.WriteString(" " + manEscaper.Replace(t.Interval))
+	}
+	w.WriteString(">")
+}
+
+func (w *ManWriter) WriteFootnoteLink(l FootnoteLink) {
+	if w.document.GetOption("f") != "nil" {
+		w.WriteString(fmt.Sprintf("[%d]", w.number(w.document, l)))
+	}
+}
+
+func (w *ManWriter) WriteCitation(c Citation) {
+	w.WriteString(w.document.formatCitation(c, manCitationMarkup))
+}
+
+func (w *ManWriter) WriteRegularLink(l RegularLink) { writePlainLink(w, l) }
+
+func (w *ManWriter) WriteMacro(m Macro) {
+	w.document.writeMacro(w, m, w.headline)
+}
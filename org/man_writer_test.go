@@ -0,0 +1,67 @@
+package org
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManWriter(t *testing.T) {
+	for _, path := range orgTestFiles() {
+		expected := fileString(path[:len(path)-len(".org")] + ".man")
+		reader, writer := strings.NewReader(fileString(path)), NewManWriter()
+		actual, err := New().Silent().Parse(reader, path).Write(writer)
+		if err != nil {
+			t.Errorf("%s\n got error: %s", path, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%s:\n%s'", path, diff(actual, expected))
+		} else {
+			t.Logf("%s: passed!", path)
+		}
+	}
+}
+
+func TestManWriterEscaping(t *testing.T) {
+	input := "#+TITLE: go-org\n#+MAN_SECTION: 7\n\ngo-org - text\\\\\n.with a leading dot and a \\ backslash\n.br\n\n#+BEGIN_SRC sh\n.hidden \\n\n#+END_SRC\n"
+	expected := `.TH "GO\-ORG" "7"
+.PP
+go\-org \- text
+.br
+\&.with a leading dot and a \e backslash
+\&.br
+.PP
+.RS 4
+.nf
+\&.hidden \en
+.fi
+.RE
+`
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewManWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
+
+func TestManWriterTableFootnotes(t *testing.T) {
+	input := "| a | b[fn:1] |\n\n[fn:1] note\n"
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewManWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if !strings.Contains(actual, "b[1]") || !strings.Contains(actual, ".SH \"NOTES\"\n.IP [1] 4\nnote\n") {
+		t.Errorf("expected the footnote of the table cell to be written:\n%s", actual)
+	}
+}
+
+func TestManWriterHeadlineOptions(t *testing.T) {
+	input := "#+TITLE: test\n#+OPTIONS: num:t H:1\n\n* Headline\n** List headline\ncontent\n"
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewManWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if expected := ".TH \"TEST\" \"1\"\n.SH \"1 Headline\"\n.IP \"\\(bu\" 4\nList headline\n.IP\ncontent\n"; actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
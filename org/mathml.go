@@ -0,0 +1,462 @@
+package org
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// latexParser converts a subset of LaTeX math to MathML (see HTMLWriter.MathML).
+// Commands that are not supported result in an error - the fragment is written as is in that case.
+type latexParser struct {
+	s       string
+	pos     int
+	display bool
+}
+
+// mathSymbols contains the symbols that are missing from the entity table (see htmlEntities).
+var mathSymbols = map[string]string{
+	`\coprod`: "∐", `\bigcup`: "⋃", `\bigcap`: "⋂", `\bigoplus`: "⨁", `\bigotimes`: "⨂", `\bigvee`: "⋁", `\bigwedge`: "⋀",
+	`\iint`: "∬", `\iiint`: "∭", `\oint`: "∮", `\ldots`: "…", `\vdots`: "⋮", `\ddots`: "⋱", `\subseteq`: "⊆",
+	`\supseteq`: "⊇", `\mid`: "∣", `\iff`: "⟺", `\implies`: "⟹", `\mapsto`: "↦", `\Vert`: "‖", `\|`: "‖",
+	`\lbrace`: "{", `\rbrace`: "}", `\{`: "{", `\}`: "}", `\%`: "%", `\$`: "$", `\#`: "#", `\&`: "&", `\_`: "_",
+}
+
+// mathIdentifiers are the non-letter symbols that are written as identifiers (<mi>) rather than operators (<mo>).
+var mathIdentifiers = map[string]bool{
+	`\infty`: true, `\partial`: true, `\emptyset`: true, `\empty`: true, `\nabla`: true, `\hbar`: true, `\ell`: true,
+	`\aleph`: true, `\Re`: true, `\Im`: true, `\wp`: true,
+}
+
+// mathLimitOperators have their limits written below and above them in display mode (e.g. \sum_{i=1}^n).
+var mathLimitOperators = map[string]bool{
+	`\sum`: true, `\prod`: true, `\coprod`: true, `\bigcup`: true, `\bigcap`: true, `\bigoplus`: true,
+	`\bigotimes`: true, `\bigvee`: true, `\bigwedge`: true, `\lim`: true, `\liminf`: true, `\limsup`: true,
+	`\max`: true, `\min`: true, `\sup`: true, `\inf`: true, `\det`: true, `\gcd`: true, `\Pr`: true,
+}
+
+var mathFunctions = map[string]bool{
+	`\sin`: true, `\cos`: true, `\tan`: true, `\cot`: true, `\sec`: true, `\csc`: true, `\arcsin`: true,
+	`\arccos`: true, `\arctan`: true, `\sinh`: true, `\cosh`: true, `\tanh`: true, `\log`: true, `\ln`: true,
+	`\lg`: true, `\exp`: true, `\dim`: true, `\arg`: true, `\deg`: true, `\ker`: true, `\hom`: true,
+	`\lim`: true, `\liminf`: true, `\limsup`: true, `\max`: true, `\min`: true, `\sup`: true, `\inf`: true,
+	`\det`: true, `\gcd`: true, `\Pr`: true,
+}
+
+var mathSpaces = map[string]string{
+	`\,`: "0.167em", `\:`: "0.222em", `\>`: "0.222em", `\;`: "0.278em", `\!`: "-0.167em", `\ `: "0.333em",
+	`\quad`: "1em", `\qquad`: "2em",
+}
+
+var mathVariants = map[string]string{
+	`\mathrm`: "normal", `\mathbf`: "bold", `\mathit`: "italic", `\mathbb`: "double-struck", `\mathcal`: "script",
+	`\mathfrak`: "fraktur", `\mathsf`: "sans-serif", `\mathtt`: "monospace", `\operatorname`: "normal",
+}
+
+var mathAccents = map[string]string{
+	`\hat`: "^", `\widehat`: "^", `\bar`: "‾", `\overline`: "‾", `\vec`: "→", `\dot`: "˙", `\ddot`: "¨",
+	`\tilde`: "~", `\widetilde`: "~",
+}
+
+var mathDelimiters = map[string]string{
+	"(": "(", ")": ")", "[": "[", "]": "]", "|": "|", "/": "/", ".": "", `\{`: "{", `\}`: "}", `\lbrace`: "{",
+	`\rbrace`: "}", `\|`: "‖", `\vert`: "|", `\Vert`: "‖", `\langle`: "⟨", `\rangle`: "⟩", `\lfloor`: "⌊",
+	`\rfloor`: "⌋", `\lceil`: "⌈", `\rceil`: "⌉",
+}
+
+// mathMatrixFences contains the fences of the supported matrix environments.
+var mathMatrixFences = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""}, "array": {"", ""},
+}
+
+var mathEntities map[string]string
+
+func init() {
+	mathEntities = map[string]string{}
+	for i := 0; i+1 < len(htmlEntities); i += 2 {
+		if strings.HasPrefix(htmlEntities[i], `\`) {
+			mathEntities[htmlEntities[i]] = htmlEntities[i+1]
+		}
+	}
+	for k, v := range mathSymbols {
+		mathEntities[k] = v
+	}
+}
+
+// latexToMathML converts the LaTeX math source to a <math> element.
+func latexToMathML(source string, display bool) (string, error) {
+	p := &latexParser{s: source, display: display}
+	items, err := p.parseRow()
+	if err != nil {
+		return "", err
+	}
+	if token, _ := p.peek(); token != "" {
+		return "", fmt.Errorf("unexpected %q", token)
+	}
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return fmt.Sprintf(`<math xmlns="http://www.w3.org/1998/Math/MathML" display="%s">`, mode) +
+		"<semantics>" + mrow(items) +
+		`<annotation encoding="application/x-tex">` + html.EscapeString(strings.TrimSpace(source)) + "</annotation>" +
+		"</semantics></math>", nil
+}
+
+func (p *latexParser) peek() (string, int) {
+	i := p.pos
+	for i < len(p.s) && unicode.IsSpace(rune(p.s[i])) {
+		i++
+	}
+	if i >= len(p.s) {
+		return "", i
+	}
+	switch c := p.s[i]; {
+	case c == '\\':
+		j := i + 1
+		for j < len(p.s) && (('a' <= p.s[j] && p.s[j] <= 'z') || ('A' <= p.s[j] && p.s[j] <= 'Z')) {
+			j++
+		}
+		if j == i+1 && j < len(p.s) {
+			j++
+		}
+		return p.s[i:j], j
+	case '0' <= c && c <= '9':
+		j := i
+		for j < len(p.s) && (('0' <= p.s[j] && p.s[j] <= '9') || (p.s[j] == '.' && j+1 < len(p.s) && '0' <= p.s[j+1] && p.s[j+1] <= '9')) {
+			j++
+		}
+		return p.s[i:j], j
+	default:
+		_, size := utf8.DecodeRuneInString(p.s[i:])
+		return p.s[i : i+size], i + size
+	}
+}
+
+func (p *latexParser) next() string {
+	token, end := p.peek()
+	p.pos = end
+	return token
+}
+
+func (p *latexParser) expect(expected string) error {
+	if token := p.next(); token != expected {
+		return fmt.Errorf("expected %q, got %q", expected, token)
+	}
+	return nil
+}
+
+// rawGroup returns the unparsed content of the following {...} group - e.g. for \text{...}.
+func (p *latexParser) rawGroup() (string, error) {
+	if err := p.expect("{"); err != nil {
+		return "", err
+	}
+	for i, lvl := p.pos, 0; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '\\':
+			i++
+		case '{':
+			lvl++
+		case '}':
+			if lvl == 0 {
+				content := p.s[p.pos:i]
+				p.pos = i + 1
+				return content, nil
+			}
+			lvl--
+		}
+	}
+	return "", fmt.Errorf("unclosed group")
+}
+
+// parseRow parses the expressions up to the end of the current group, cell, row or environment.
+// Additional stop tokens (e.g. the ] of \sqrt[n]{x}) can be passed.
+func (p *latexParser) parseRow(stops ...string) ([]string, error) {
+	items := []string{}
+	for {
+		token, _ := p.peek()
+		switch token {
+		case "", "}", "&", `\\`, `\end`, `\right`:
+			return items, nil
+		}
+		for _, stop := range stops {
+			if token == stop {
+				return items, nil
+			}
+		}
+		item, err := p.parseScripted()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func (p *latexParser) parseScripted() (string, error) {
+	base, limits, err := p.parseAtom()
+	if err != nil {
+		return "", err
+	}
+	sub, sup := "", ""
+	for {
+		token, _ := p.peek()
+		if token == "'" {
+			p.next()
+			sup += "<mo>′</mo>"
+			continue
+		} else if token != "_" && token != "^" {
+			break
+		}
+		p.next()
+		script, _, err := p.parseAtom()
+		if err != nil {
+			return "", err
+		}
+		if token == "_" && sub == "" {
+			sub = script
+		} else if token == "^" && sup == "" {
+			sup = script
+		} else {
+			return "", fmt.Errorf("double %s", token)
+		}
+	}
+	if strings.Count(sup, "<mo>′</mo>") > 1 {
+		sup = "<mrow>" + sup + "</mrow>"
+	}
+	under, over, both := "msub", "msup", "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != "" && sup != "":
+		return fmt.Sprintf("<%s>%s%s%s</%s>", both, base, sub, sup, both), nil
+	case sub != "":
+		return fmt.Sprintf("<%s>%s%s</%s>", under, base, sub, under), nil
+	case sup != "":
+		return fmt.Sprintf("<%s>%s%s</%s>", over, base, sup, over), nil
+	}
+	return base, nil
+}
+
+// parseAtom parses a single (unscripted) expression. It returns whether the limits of the expression
+// are written below and above it in display mode (see mathLimitOperators).
+func (p *latexParser) parseAtom() (string, bool, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return "", false, fmt.Errorf("unexpected end")
+	case token == "{":
+		items, err := p.parseRow()
+		if err != nil {
+			return "", false, err
+		}
+		return mrow(items), false, p.expect("}")
+	case '0' <= token[0] && token[0] <= '9':
+		return "<mn>" + token + "</mn>", false, nil
+	case token[0] == '\\':
+		return p.parseCommand(token)
+	case strings.Contains("+-=<>()[]|/,;:!?.*", token):
+		return "<mo>" + html.EscapeString(strings.NewReplacer("-", "−", "*", "∗").Replace(token)) + "</mo>", false, nil
+	case unicode.IsLetter([]rune(token)[0]):
+		return "<mi>" + token + "</mi>", false, nil
+	}
+	return "", false, fmt.Errorf("unexpected %q", token)
+}
+
+func (p *latexParser) parseCommand(command string) (string, bool, error) {
+	switch command {
+	case `\frac`, `\dfrac`, `\tfrac`, `\cfrac`, `\binom`:
+		numerator, _, err := p.parseAtom()
+		if err != nil {
+			return "", false, err
+		}
+		denominator, _, err := p.parseAtom()
+		if err != nil {
+			return "", false, err
+		}
+		if command == `\binom` {
+			return `<mrow><mo>(</mo><mfrac linethickness="0">` + numerator + denominator + "</mfrac><mo>)</mo></mrow>", false, nil
+		}
+		return "<mfrac>" + numerator + denominator + "</mfrac>", false, nil
+	case `\sqrt`:
+		index := ""
+		if token, _ := p.peek(); token == "[" {
+			p.next()
+			items, err := p.parseRow("]")
+			if err != nil {
+				return "", false, err
+			} else if err := p.expect("]"); err != nil {
+				return "", false, err
+			}
+			index = mrow(items)
+		}
+		radicand, _, err := p.parseAtom()
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + radicand + index + "</mroot>", false, nil
+		}
+		return "<msqrt>" + radicand + "</msqrt>", false, nil
+	case `\left`:
+		return p.parseFenced()
+	case `\text`, `\textrm`, `\mbox`:
+		text, err := p.rawGroup()
+		return "<mtext>" + html.EscapeString(text) + "</mtext>", false, err
+	case `\begin`:
+		return p.parseEnvironment()
+	}
+	if variant, ok := mathVariants[command]; ok {
+		text, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		} else if strings.ContainsAny(text, `\{}^_`) {
+			return "", false, fmt.Errorf("unsupported content of %s: %q", command, text)
+		}
+		return fmt.Sprintf(`<mi mathvariant="%s">%s</mi>`, variant, html.EscapeString(strings.TrimSpace(text))), false, nil
+	} else if accent, ok := mathAccents[command]; ok {
+		base, _, err := p.parseAtom()
+		return `<mover accent="true">` + base + "<mo>" + accent + "</mo></mover>", false, err
+	} else if width, ok := mathSpaces[command]; ok {
+		return fmt.Sprintf(`<mspace width="%s"/>`, width), false, nil
+	} else if mathFunctions[command] {
+		return "<mi>" + command[1:] + "</mi>", mathLimitOperators[command], nil
+	} else if symbol, ok := mathEntities[command]; ok {
+		r, _ := utf8.DecodeRuneInString(symbol)
+		switch {
+		case unicode.IsUpper(r):
+			return `<mi mathvariant="normal">` + symbol + "</mi>", false, nil
+		case unicode.IsLetter(r) || mathIdentifiers[command]:
+			return "<mi>" + symbol + "</mi>", false, nil
+		default:
+			return "<mo>" + html.EscapeString(symbol) + "</mo>", mathLimitOperators[command], nil
+		}
+	}
+	return "", false, fmt.Errorf("unsupported command %s", command)
+}
+
+func (p *latexParser) parseFenced() (string, bool, error) {
+	open, err := p.delimiter()
+	if err != nil {
+		return "", false, err
+	}
+	items, err := p.parseRow()
+	if err != nil {
+		return "", false, err
+	} else if err := p.expect(`\right`); err != nil {
+		return "", false, err
+	}
+	close, err := p.delimiter()
+	if err != nil {
+		return "", false, err
+	}
+	return mrow([]string{fence(open), mrow(items), fence(close)}), false, nil
+}
+
+func (p *latexParser) delimiter() (string, error) {
+	token := p.next()
+	if delimiter, ok := mathDelimiters[token]; ok {
+		return delimiter, nil
+	}
+	return "", fmt.Errorf("unsupported delimiter %q", token)
+}
+
+// parseEnvironment parses matrices (e.g. pmatrix, cases) and multi-line equations (e.g. align) into tables.
+func (p *latexParser) parseEnvironment() (string, bool, error) {
+	name, err := p.rawGroup()
+	if err != nil {
+		return "", false, err
+	}
+	fences, isMatrix := mathMatrixFences[name]
+	columnAlign := ""
+	switch name {
+	case "array":
+		if _, err := p.rawGroup(); err != nil {
+			return "", false, err
+		}
+	case "cases":
+		columnAlign = "left"
+	case "align", "align*", "aligned", "split", "eqnarray", "eqnarray*", "alignat", "alignat*":
+		columnAlign = "right left"
+		if strings.HasPrefix(name, "alignat") {
+			if _, err := p.rawGroup(); err != nil {
+				return "", false, err
+			}
+		}
+	case "gather", "gather*", "gathered", "equation", "equation*", "multline", "multline*":
+		columnAlign = "center"
+	default:
+		if !isMatrix {
+			return "", false, fmt.Errorf("unsupported environment %s", name)
+		}
+	}
+	rows, row := [][]string{}, []string{}
+	for {
+		items, err := p.parseRow()
+		if err != nil {
+			return "", false, err
+		}
+		row = append(row, mrow(items))
+		switch token := p.next(); token {
+		case "&":
+			continue
+		case `\\`:
+			rows, row = append(rows, row), []string{}
+			continue
+		case `\end`:
+			if end, err := p.rawGroup(); err != nil {
+				return "", false, err
+			} else if end != name {
+				return "", false, fmt.Errorf("\\begin{%s} ended by \\end{%s}", name, end)
+			}
+		default:
+			return "", false, fmt.Errorf("unexpected %q in %s", token, name)
+		}
+		break
+	}
+	if len(row) != 1 || row[0] != mrow(nil) {
+		rows = append(rows, row)
+	}
+	if !isMatrix && len(rows) == 1 && len(rows[0]) == 1 {
+		return rows[0][0], false, nil
+	}
+	table := strings.Builder{}
+	if columnAlign != "" && len(rows) != 0 {
+		columns := strings.Fields(columnAlign)
+		for len(columns) < len(rows[0]) {
+			columns = append(columns, columns...)
+		}
+		fmt.Fprintf(&table, `<mtable columnalign="%s" displaystyle="%t">`, strings.Join(columns, " "), !isMatrix)
+	} else {
+		table.WriteString("<mtable>")
+	}
+	for _, row := range rows {
+		table.WriteString("<mtr>")
+		for _, cell := range row {
+			table.WriteString("<mtd>" + cell + "</mtd>")
+		}
+		table.WriteString("</mtr>")
+	}
+	table.WriteString("</mtable>")
+	if fences[0] == "" && fences[1] == "" {
+		return table.String(), false, nil
+	}
+	return mrow([]string{fence(fences[0]), table.String(), fence(fences[1])}), false, nil
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func fence(delimiter string) string {
+	if delimiter == "" {
+		return ""
+	}
+	return `<mo fence="true">` + html.EscapeString(delimiter) + "</mo>"
+}
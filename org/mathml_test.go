@@ -0,0 +1,58 @@
+package org
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLatexToMathML(t *testing.T) {
+	for source, expected := range map[string]string{
+		`\frac{1}{2}`:            "<mfrac><mn>1</mn><mn>2</mn></mfrac>",
+		`\alpha_i^2`:             "<msubsup><mi>α</mi><mi>i</mi><mn>2</mn></msubsup>",
+		`\sum_{i=1}^n i`:         "<munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover>",
+		`\int_0^\infty f(x)\,dx`: "<msubsup><mo>∫</mo><mn>0</mn><mi>∞</mi></msubsup>",
+		`\begin{pmatrix} 1 & 0 \\ 0 & 1 \end{pmatrix}`: `<mo fence="true">(</mo><mtable><mtr><mtd><mn>1</mn></mtd><mtd><mn>0</mn></mtd></mtr>`,
+		`\begin{align} x &= 1 \\ y &= 2 \end{align}`:   `<mtable columnalign="right left" displaystyle="true"><mtr><mtd><mi>x</mi></mtd><mtd><mrow><mo>=</mo><mn>1</mn></mrow></mtd></mtr>`,
+		`\begin{cases}\end{cases}`:                     `<mo fence="true">{</mo><mtable></mtable>`,
+		`\begin{align}\end{align}`:                     `<mtable></mtable>`,
+	} {
+		actual, err := latexToMathML(source, true)
+		if err != nil {
+			t.Errorf("%s: got error: %s", source, err)
+		} else if !strings.Contains(actual, expected) {
+			t.Errorf("%s: expected output to contain %q:\n%s", source, expected, actual)
+		}
+	}
+	for _, source := range []string{`\unknown{x}`, `\frac{1}`, `a & b`, `\begin{align} x \end{gather}`} {
+		if _, err := latexToMathML(source, false); err == nil {
+			t.Errorf("%s: expected error", source)
+		}
+	}
+}
+
+func TestMathMLHTMLWriter(t *testing.T) {
+	input := `inline \(a^2\), block \[\frac{1}{2}\] and unsupported \(\unknown{x}\)` + "\n"
+	writer := NewHTMLWriter()
+	writer.MathML = true
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" display="inline"><semantics><msup><mi>a</mi><mn>2</mn></msup>`,
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" display="block"><semantics><mfrac>`,
+		`unsupported \(\unknown{x}\)`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+	writer = NewHTMLWriter()
+	writer.MathML, writer.Safe = true, true
+	actual, err = New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if !strings.Contains(actual, `<math display="block"><semantics><mfrac>`) {
+		t.Errorf("expected safe output to keep MathML:\n%s", actual)
+	}
+}
@@ -2,7 +2,9 @@ package org
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -12,9 +14,14 @@ import (
 type OrgWriter struct {
 	ExtendingWriter Writer
 	TagsColumn      int
+	ExpandMacros    bool                         // ExpandMacros writes the expansion of macros rather than the macros themselves.
+	NodeWriters     map[reflect.Type]NodeWriteFn // NodeWriters write custom nodes (see Extensions) - keyed by the type of the node.
 
 	strings.Builder
-	indent string
+	indent   string
+	document *Document
+	headline *Headline
+	strict   bool // strict writers do not fall back to plain text for nodes that cannot be written (see nodeString).
 }
 
 var exampleBlockUnescapeRegexp = regexp.MustCompile(`(^|\n)([ \t]*)(\*|,\*|#\+|,#\+)`)
@@ -43,16 +50,22 @@ func (w *OrgWriter) WriterWithExtensions() Writer {
 	return w
 }
 
-func (w *OrgWriter) Before(d *Document) {}
+func (w *OrgWriter) WriteCustomNode(n Node) {
+	writeCustomNode(w.WriterWithExtensions(), w.NodeWriters, n)
+}
+
+func (w *OrgWriter) writtenDocument() *Document { return w.document }
+func (w *OrgWriter) builder() *strings.Builder  { return &w.Builder }
+
+func (w *OrgWriter) Before(d *Document) { w.document = d }
 func (w *OrgWriter) After(d *Document)  {}
 
 func (w *OrgWriter) WriteNodesAsString(nodes ...Node) string {
 	builder := w.Builder
 	w.Builder = strings.Builder{}
+	defer func() { w.Builder = builder }() // restored on panics as well - see writeNode
 	WriteNodes(w, nodes...)
-	out := w.String()
-	w.Builder = builder
-	return out
+	return w.String()
 }
 
 func (w *OrgWriter) WriteHeadline(h Headline) {
@@ -81,16 +94,27 @@ func (w *OrgWriter) WriteHeadline(h Headline) {
 	if h.Properties != nil {
 		WriteNodes(w, *h.Properties)
 	}
+	parentHeadline := w.headline
+	w.headline = &h
 	WriteNodes(w, h.Children...)
+	w.headline = parentHeadline
 }
 
 func (w *OrgWriter) WriteBlock(b Block) {
 	w.WriteString(w.indent + "#+BEGIN_" + b.Name)
-	if len(b.Parameters) != 0 {
-		w.WriteString(" " + strings.Join(b.Parameters, " "))
+	parameters := b.Parameters
+	if switches := switchesString(b.Switches); switches != "" {
+		if len(parameters) != 0 && !strings.HasPrefix(parameters[0], ":") {
+			parameters = append([]string{parameters[0], switches}, parameters[1:]...)
+		} else {
+			parameters = append([]string{switches}, parameters...)
+		}
+	}
+	if len(parameters) != 0 {
+		w.WriteString(" " + strings.Join(parameters, " "))
 	}
 	w.WriteString("\n")
-	if isRawTextBlock(b.Name) {
+	if isLineBlock(b.Name) {
 		w.WriteString(w.indent)
 	}
 	content := w.WriteNodesAsString(b.Children...)
@@ -98,7 +122,7 @@ func (w *OrgWriter) WriteBlock(b Block) {
 		content = exampleBlockUnescapeRegexp.ReplaceAllString(content, "$1$2,$3")
 	}
 	w.WriteString(content)
-	if !isRawTextBlock(b.Name) {
+	if !isLineBlock(b.Name) {
 		w.WriteString(w.indent)
 	}
 	w.WriteString("#+END_" + b.Name + "\n")
@@ -110,7 +134,11 @@ func (w *OrgWriter) WriteBlock(b Block) {
 }
 
 func (w *OrgWriter) WriteResult(r Result) {
-	w.WriteString("#+RESULTS:\n")
+	if r.Hash != "" {
+		w.WriteString("#+RESULTS[" + r.Hash + "]:\n")
+	} else {
+		w.WriteString("#+RESULTS:\n")
+	}
 	WriteNodes(w, r.Node)
 }
 
@@ -124,6 +152,7 @@ func (w *OrgWriter) WriteInlineBlock(b InlineBlock) {
 		w.WriteString("{")
 		WriteNodes(w, b.Children...)
 		w.WriteString("}")
+		w.writeInlineResult(b.Result)
 	case "export":
 		w.WriteString("@@" + b.Parameters[0] + ":")
 		WriteNodes(w, b.Children...)
@@ -166,6 +195,59 @@ func (w *OrgWriter) WriteParagraph(p Paragraph) {
 	w.WriteString(content + "\n")
 }
 
+func (w *OrgWriter) WriteCall(c Call) {
+	w.WriteString(w.indent + "#+CALL: " + callString(c.Name, c.InsideHeader, c.Arguments, c.EndHeader) + "\n")
+	if c.Result != nil {
+		w.WriteString("\n")
+		WriteNodes(w, c.Result)
+	}
+}
+
+func (w *OrgWriter) WriteInlineCall(c InlineCall) {
+	w.WriteString("call_" + callString(c.Name, c.InsideHeader, c.Arguments, c.EndHeader))
+	w.writeInlineResult(c.Result)
+}
+
+func (w *OrgWriter) writeInlineResult(result []Node) {
+	if result != nil {
+		w.WriteString(" {{{results(" + w.WriteNodesAsString(result...) + ")}}}")
+	}
+}
+
+func switchesString(s Switches) string {
+	switches := []string{}
+	if s.NumberLines != "" {
+		switches = append(switches, s.NumberLines)
+		if s.Start != 0 {
+			switches = append(switches, strconv.Itoa(s.Start))
+		}
+	}
+	if s.RemoveLabels {
+		switches = append(switches, "-r")
+	}
+	if s.KeepLabels {
+		switches = append(switches, "-k")
+	}
+	if s.PreserveIndentation {
+		switches = append(switches, "-i")
+	}
+	if s.LabelFormat != "" {
+		switches = append(switches, "-l", `"`+s.LabelFormat+`"`)
+	}
+	return strings.Join(switches, " ")
+}
+
+func callString(name, insideHeader, arguments, endHeader string) string {
+	if insideHeader != "" {
+		name += "[" + insideHeader + "]"
+	}
+	name += "(" + arguments + ")"
+	if endHeader != "" {
+		name += "[" + endHeader + "]"
+	}
+	return name
+}
+
 func (w *OrgWriter) WriteExample(e Example) {
 	for _, n := range e.Children {
 		w.WriteString(w.indent + ":")
@@ -198,6 +280,9 @@ func (w *OrgWriter) WriteNodeWithMeta(n NodeWithMeta) {
 		w.WriteString("#+ATTR_HTML: ")
 		w.WriteString(strings.Join(attributes, " ") + "\n")
 	}
+	for _, parameters := range n.Meta.Header {
+		w.WriteString("#+HEADER: " + strings.Join(parameters, " ") + "\n")
+	}
 	WriteNodes(w, n.Node)
 }
 
@@ -354,6 +439,26 @@ func (w *OrgWriter) WriteFootnoteLink(l FootnoteLink) {
 	w.WriteString("]")
 }
 
+func (w *OrgWriter) WriteCitation(c Citation) {
+	w.WriteString("[cite")
+	for _, s := range []string{c.Style, c.Variant} {
+		if s != "" {
+			w.WriteString("/" + s)
+		}
+	}
+	parts := []string{}
+	if c.Prefix != "" {
+		parts = append(parts, c.Prefix)
+	}
+	for _, r := range c.References {
+		parts = append(parts, r.Prefix+"@"+r.Key+r.Suffix)
+	}
+	if c.Suffix != "" {
+		parts = append(parts, c.Suffix)
+	}
+	w.WriteString(":" + strings.Join(parts, ";") + "]")
+}
+
 func (w *OrgWriter) WriteRegularLink(l RegularLink) {
 	if l.AutoLink {
 		w.WriteString(l.URL)
@@ -365,5 +470,17 @@ func (w *OrgWriter) WriteRegularLink(l RegularLink) {
 }
 
 func (w *OrgWriter) WriteMacro(m Macro) {
-	w.WriteString(fmt.Sprintf("{{{%s(%s)}}}", m.Name, strings.Join(m.Parameters, ",")))
+	if w.ExpandMacros && w.document != nil {
+		w.document.writeMacro(w, m, w.headline)
+		return
+	}
+	if m.Parameters == nil {
+		w.WriteString(fmt.Sprintf("{{{%s}}}", m.Name))
+		return
+	}
+	parameters := make([]string, len(m.Parameters))
+	for i, p := range m.Parameters {
+		parameters[i] = escapeMacroArgument(p)
+	}
+	w.WriteString(fmt.Sprintf("{{{%s(%s)}}}", m.Name, strings.Join(parameters, ",")))
 }
@@ -84,3 +84,17 @@ func diff(actual, expected string) string {
 	text, _ := difflib.GetUnifiedDiffString(diff)
 	return text
 }
+
+func TestOrgWriterExpandMacros(t *testing.T) {
+	input := "#+MACRO: greet Hello $1, how are /you/?\n{{{greet(Doe\\, Jane)}}} {{{n}}} {{{n}}}\n"
+	writer := NewOrgWriter()
+	writer.ExpandMacros = true
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	expected := "#+MACRO: greet Hello $1, how are /you/?\nHello Doe, Jane, how are /you/? 1 2\n"
+	if actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
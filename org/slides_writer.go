@@ -0,0 +1,229 @@
+package org
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// SlidesWriter exports an org document into a standalone reveal.js presentation (see org-reveal).
+//
+// Headlines up to the slide level are written as slides, their child headlines as vertical slides below them
+// and all deeper headlines as content of their slide. #+BEGIN_NOTES blocks are written as speaker notes.
+// The slide content is written by the embedded HTMLWriter - its options (e.g. HighlightCodeBlock) apply.
+// With SectionFootnotes, footnotes are written at the end of the slide that first references them.
+type SlidesWriter struct {
+	*HTMLWriter
+	// RevealRoot is the path of the local reveal.js distribution (dist/ and plugin/) the presentation references.
+	// It is overridden by #+REVEAL_ROOT - unless Safe is set, as the document must not choose the scripts that are loaded.
+	RevealRoot string
+	Theme      string // Theme is the name of the reveal.js theme - e.g. black or white. It is overridden by #+REVEAL_THEME unless Safe is set.
+	SlideLevel int    // SlideLevel is the deepest headline level written as (horizontal) slides. It is overridden by #+REVEAL_HLEVEL.
+
+	inSlide bool
+	inStack bool
+}
+
+// slideAttributes maps the properties of headlines to the attributes of their slides.
+var slideAttributes = [][2]string{
+	{"REVEAL_BACKGROUND", "data-background"},
+	{"REVEAL_BACKGROUND_SIZE", "data-background-size"},
+	{"REVEAL_BACKGROUND_POSITION", "data-background-position"},
+	{"REVEAL_BACKGROUND_REPEAT", "data-background-repeat"},
+	{"REVEAL_BACKGROUND_TRANS", "data-background-transition"},
+	{"REVEAL_DATA_TRANSITION", "data-transition"},
+	{"REVEAL_DATA_STATE", "data-state"},
+}
+
+// isSlideAttribute returns true for the attributes of slides (see slideAttributes) - they are kept on sections in Safe mode.
+func isSlideAttribute(key string) bool {
+	for _, kv := range slideAttributes {
+		if kv[1] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func NewSlidesWriter() *SlidesWriter {
+	w := &SlidesWriter{
+		HTMLWriter: NewHTMLWriter(),
+		RevealRoot: "reveal.js",
+		Theme:      "black",
+		SlideLevel: 1,
+	}
+	w.HTMLWriter.ExtendingWriter = w
+	w.SpecialBlocks = map[string]BlockElement{"NOTES": {"aside", "notes"}}
+	return w
+}
+
+func (w *SlidesWriter) Before(d *Document) {
+	w.document, w.log = d, d.Log
+	w.sectionNumbers = d.sectionNumbers()
+	root := w.revealRoot()
+	w.writeHead(d, root+"/dist/reveal.css", root+"/dist/theme/"+w.theme()+".css")
+	w.WriteString(`<div class="reveal">` + "\n" + `<div class="slides">` + "\n")
+	w.bodyStart = w.Len()
+	w.writeTitleSlide(d)
+	if d.GetOption("toc") != "nil" {
+		maxLvl, _ := strconv.Atoi(d.GetOption("toc"))
+		if toc := w.writeAsString(func() { w.WriteOutline(d, maxLvl) }); toc != "" {
+			w.WriteString(`<section id="table-of-contents">` + "\n" + toc + "</section>\n")
+		}
+	}
+}
+
+func (w *SlidesWriter) After(d *Document) {
+	if footnotes := w.writeAsString(func() { w.WriteFootnotes(d) }); footnotes != "" {
+		w.WriteString(`<section id="footnotes">` + "\n" + footnotes + "</section>\n")
+	}
+	if w.Safe {
+		w.sanitize()
+	}
+	w.WriteString("</div>\n</div>\n")
+	root := w.revealRoot()
+	for _, script := range []string{root + "/dist/reveal.js", root + "/plugin/notes/notes.js"} {
+		w.WriteString(fmt.Sprintf(`<script src="%s"></script>`+"\n", html.EscapeString(script)))
+	}
+	options := "hash: true"
+	if transition := d.Get("REVEAL_TRANS"); transition != "" {
+		bs, _ := json.Marshal(transition)
+		options += ", transition: " + string(bs)
+	}
+	w.WriteString(fmt.Sprintf("<script>\nReveal.initialize({%s, plugins: [RevealNotes]});\n</script>\n", options))
+	w.WriteString("</body>\n</html>\n")
+}
+
+// writeAsString returns the output of write instead of writing it.
+func (w *SlidesWriter) writeAsString(write func()) string {
+	original := w.Builder
+	w.Builder = strings.Builder{}
+	write()
+	out := w.String()
+	w.Builder = original
+	return out
+}
+
+func (w *SlidesWriter) setting(key, fallback string) string {
+	if value := w.document.Get(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// revealRoot returns the reveal.js root - the head and scripts are written outside of the sanitized body,
+// so #+REVEAL_ROOT is ignored in Safe mode.
+func (w *SlidesWriter) revealRoot() string {
+	if w.Safe {
+		return strings.TrimSuffix(w.RevealRoot, "/")
+	}
+	return strings.TrimSuffix(w.setting("REVEAL_ROOT", w.RevealRoot), "/")
+}
+
+// theme returns the reveal.js theme - #+REVEAL_THEME is ignored in Safe mode (see revealRoot).
+func (w *SlidesWriter) theme() string {
+	if w.Safe {
+		return w.Theme
+	}
+	return w.setting("REVEAL_THEME", w.Theme)
+}
+
+func (w *SlidesWriter) slideLevel() int {
+	if lvl, err := strconv.Atoi(w.document.Get("REVEAL_HLEVEL")); err == nil && lvl > 0 {
+		return lvl
+	}
+	return w.SlideLevel
+}
+
+func (w *SlidesWriter) writeTitleSlide(d *Document) {
+	title := d.Get("TITLE")
+	if title == "" || d.GetOption("title") == "nil" {
+		return
+	}
+	titleDocument := d.parseSubDocument(title)
+	if p, ok := firstParagraph(titleDocument.Nodes); ok && titleDocument.Error == nil {
+		title = w.WriteNodesAsString(p.Children...)
+	} else {
+		title = html.EscapeString(title)
+	}
+	w.WriteString(`<section id="title-slide">` + "\n")
+	w.WriteString(fmt.Sprintf(`<h1 class="title">%s</h1>`+"\n", strings.TrimSpace(title)))
+	for _, key := range []string{"AUTHOR", "DATE"} {
+		if value := d.Get(key); value != "" {
+			w.WriteString(fmt.Sprintf(`<p class="%s">%s</p>`+"\n", strings.ToLower(key), html.EscapeString(value)))
+		}
+	}
+	w.WriteString("</section>\n")
+}
+
+// WriteHeadline writes h as a slide - or, inside of a slide, as content of that slide.
+// Slides with child headlines at or below the slide level are written as a vertical stack of slides.
+func (w *SlidesWriter) WriteHeadline(h Headline) {
+	if h.IsExcluded(w.document) {
+		return
+	} else if w.inSlide {
+		w.HTMLWriter.WriteHeadline(h)
+		return
+	} else if w.inStack {
+		w.writeSlide(h, h.Children)
+		return
+	}
+	content, headlines := []Node{}, []Node{}
+	for _, n := range h.Children {
+		if child, ok := n.(Headline); !ok {
+			content = append(content, n)
+		} else if !child.IsExcluded(w.document) {
+			headlines = append(headlines, n)
+		}
+	}
+	if h.Lvl < w.slideLevel() || len(headlines) == 0 {
+		w.writeSlide(h, content)
+		WriteNodes(w, headlines...)
+		return
+	}
+	w.WriteString("<section>\n")
+	w.writeSlide(h, content)
+	w.inStack = true
+	WriteNodes(w, headlines...)
+	w.inStack = false
+	w.WriteString("</section>\n")
+}
+
+func (w *SlidesWriter) writeSlide(h Headline, children []Node) {
+	attributes := ""
+	for _, kv := range slideAttributes {
+		if value, ok := slideProperty(h, kv[0]); ok {
+			attributes += fmt.Sprintf(` %s="%s"`, kv[1], html.EscapeString(value))
+		}
+	}
+	w.WriteString(fmt.Sprintf(`<section id="%s"%s>`, h.ID(), attributes) + "\n")
+	w.WriteString(fmt.Sprintf("<h%d>", h.Lvl+1))
+	if number, ok := w.sectionNumbers[h.Index]; ok {
+		w.WriteString(fmt.Sprintf(`<span class="section-number-%d">%s</span> `, h.Lvl+1, number))
+	}
+	w.writeHeadlineTitle(h)
+	w.WriteString(fmt.Sprintf("</h%d>\n", h.Lvl+1))
+	parentHeadline, inSlide := w.headline, w.inSlide
+	w.headline, w.inSlide = &h, true
+	WriteNodes(w, children...)
+	w.headline, w.inSlide = parentHeadline, inSlide
+	if w.FootnoteStyle == SectionFootnotes {
+		w.WriteFootnotes(w.document)
+	}
+	w.WriteString("</section>\n")
+}
+
+// slideProperty returns the property key of h - property keys are case insensitive (e.g. :reveal_background:).
+func slideProperty(h Headline, key string) (string, bool) {
+	if h.Properties == nil {
+		return "", false
+	}
+	for _, kv := range h.Properties.Properties {
+		if strings.EqualFold(kv[0], key) {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
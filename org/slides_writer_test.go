@@ -0,0 +1,97 @@
+package org
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlidesWriter(t *testing.T) {
+	input := `#+TITLE: Talk
+#+OPTIONS: toc:nil
+#+REVEAL_HLEVEL: 2
+#+REVEAL_ROOT: ./vendor/reveal.js/
+
+* Part
+** Slide
+:PROPERTIES:
+:reveal_background: #123456
+:END:
+#+BEGIN_NOTES
+Notes
+#+END_NOTES
+*** Vertical
+text
+`
+	expected := `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Talk</title>
+<link rel="stylesheet" href="./vendor/reveal.js/dist/reveal.css">
+<link rel="stylesheet" href="./vendor/reveal.js/dist/theme/black.css">
+</head>
+<body>
+<div class="reveal">
+<div class="slides">
+<section id="title-slide">
+<h1 class="title">Talk</h1>
+</section>
+<section id="headline-1">
+<h2>Part</h2>
+</section>
+<section>
+<section id="headline-2" data-background="#123456">
+<h3>Slide</h3>
+<aside class="notes">
+<p>Notes</p>
+</aside>
+</section>
+<section id="headline-3">
+<h4>Vertical</h4>
+<p>text</p>
+</section>
+</section>
+</div>
+</div>
+<script src="./vendor/reveal.js/dist/reveal.js"></script>
+<script src="./vendor/reveal.js/plugin/notes/notes.js"></script>
+<script>
+Reveal.initialize({hash: true, plugins: [RevealNotes]});
+</script>
+</body>
+</html>
+`
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewSlidesWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if actual != expected {
+		t.Errorf("%s", diff(actual, expected))
+	}
+}
+
+func TestSafeSlidesWriter(t *testing.T) {
+	input := "#+REVEAL_ROOT: https://evil.example\n#+REVEAL_THEME: ../../evil\n\n* Slide\n:PROPERTIES:\n:reveal_background: #123456\n:reveal_data_transition: zoom\n:END:\ntext[fn:1]\n\n[fn:1] note\n* Other\n:PROPERTIES:\n:reveal_background: javascript:alert(1)\n:END:\n"
+	w := NewSlidesWriter()
+	w.Safe, w.FootnoteStyle = true, SectionFootnotes
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(w)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	for _, expected := range []string{
+		`<section id="headline-1" data-background="#123456" data-transition="zoom">`,
+		`<section id="headline-2">`,
+		`<link rel="stylesheet" href="reveal.js/dist/theme/black.css">`,
+		`<script src="reveal.js/dist/reveal.js"></script>`,
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, actual)
+		}
+	}
+	if strings.Contains(actual, "evil") {
+		t.Errorf("expected #+REVEAL_ROOT and #+REVEAL_THEME to be ignored in safe mode:\n%s", actual)
+	}
+	if i, j := strings.Index(actual, `class="footnotes"`), strings.Index(actual, `id="headline-2"`); i == -1 || i > j {
+		t.Errorf("expected the footnotes to be written at the end of the first slide:\n%s", actual)
+	}
+}
@@ -134,4 +134,4 @@ func isSpecialRow(rawColumns []string) bool {
 	return isAlignRow
 }
 
-func (n Table) String() string { return orgWriter.WriteNodesAsString(n) }
+func (n Table) String() string { return NewOrgWriter().WriteNodesAsString(n) }
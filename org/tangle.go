@@ -0,0 +1,192 @@
+package org
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// TangledFile is a file extracted from the src blocks of a document (see Document.Tangle).
+type TangledFile struct {
+	Path    string // Path is the :tangle target. Relative paths are relative to the directory of the document.
+	Content string
+	Mode    fs.FileMode // Mode is 0755 for files with a :shebang and 0644 otherwise.
+	Mkdirp  bool        // Mkdirp is set if missing parent directories should be created (:mkdirp yes).
+}
+
+var nowebReferenceRegexp = regexp.MustCompile(`<<([^<>\s]+)>>`)
+
+var tangleLangExtensions = map[string]string{
+	"bash":       "sh",
+	"shell":      "sh",
+	"python":     "py",
+	"ruby":       "rb",
+	"perl":       "pl",
+	"javascript": "js",
+	"typescript": "ts",
+	"rust":       "rs",
+	"haskell":    "hs",
+	"emacs-lisp": "el",
+	"elisp":      "el",
+	"c++":        "cpp",
+}
+
+var tangleCommentPrefixes = map[string]string{
+	"sh":         "#",
+	"bash":       "#",
+	"shell":      "#",
+	"python":     "#",
+	"ruby":       "#",
+	"perl":       "#",
+	"makefile":   "#",
+	"yaml":       "#",
+	"conf":       "#",
+	"emacs-lisp": ";;",
+	"elisp":      ";;",
+	"lisp":       ";;",
+	"scheme":     ";;",
+	"clojure":    ";;",
+	"sql":        "--",
+	"haskell":    "--",
+	"lua":        "--",
+}
+
+// Tangle extracts the src blocks of the document into files - see https://orgmode.org/manual/Extracting-Source-Code.html.
+// Header arguments are inherited from #+PROPERTY keywords and headline properties (see Block.HeaderArgs).
+// Supported header arguments: :tangle, :mkdirp, :comments link, :shebang, :padline and :noweb.
+// Noweb references (<<name>>) are resolved against the src blocks in NamedNodes.
+func (d *Document) Tangle() ([]TangledFile, error) {
+	if d.Error != nil {
+		return nil, d.Error
+	}
+	files, indexes, err := []TangledFile{}, map[string]int{}, error(nil)
+	blockCounts := map[*Headline]int{}
+	walkSrcBlocks(d.Nodes, nil, func(b Block, headlines []*Headline) {
+		if err != nil || isCommented(headlines) {
+			return
+		}
+		args := map[string]string{":tangle": "no", ":comments": "no", ":padline": "yes", ":noweb": "no", ":mkdirp": "no"}
+		for k, v := range b.HeaderArgs {
+			args[k] = v
+		}
+		path := d.tangleTarget(args)
+		if path == "" {
+			return
+		}
+		content := String(b.Children)
+		if !b.Switches.KeepLabels {
+			content = codeRefLabelRegexp(b.Switches.LabelFormat).ReplaceAllString(content, "")
+		}
+		if noweb := args[":noweb"]; noweb == "yes" || noweb == "tangle" || noweb == "no-export" || noweb == "strip-export" {
+			if content, err = d.expandNoweb(content, nil); err != nil {
+				return
+			}
+		} else if noweb == "strip-tangle" {
+			content = nowebReferenceRegexp.ReplaceAllString(content, "")
+		}
+		if args[":comments"] == "link" {
+			var h *Headline
+			if len(headlines) != 0 {
+				h = headlines[len(headlines)-1]
+			}
+			blockCounts[h]++
+			content = d.tangleLinkComments(content, path, args[":lang"], h, blockCounts[h])
+		}
+
+		i, ok := indexes[path]
+		if !ok {
+			i, indexes[path] = len(files), len(files)
+			files = append(files, TangledFile{Path: path, Mode: 0644})
+			if shebang := strings.Trim(args[":shebang"], `"`); shebang != "" {
+				files[i].Content, files[i].Mode = shebang+"\n", 0755
+			}
+		} else if args[":padline"] != "no" {
+			files[i].Content += "\n"
+		}
+		files[i].Content += content
+		files[i].Mkdirp = files[i].Mkdirp || args[":mkdirp"] == "yes"
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (d *Document) tangleTarget(args map[string]string) string {
+	switch target := strings.Trim(args[":tangle"], `"`); target {
+	case "no", "":
+		return ""
+	case "yes":
+		extension, ok := tangleLangExtensions[args[":lang"]]
+		if !ok {
+			extension = args[":lang"]
+		}
+		base := filepath.Base(d.Path)
+		return strings.TrimSuffix(base, filepath.Ext(base)) + "." + extension
+	default:
+		return target
+	}
+}
+
+// expandNoweb replaces noweb references with the (expanded) content of the referenced src block.
+// As in Org mode, text before a reference is repeated as prefix for each line of its expansion.
+func (d *Document) expandNoweb(content string, stack []string) (string, error) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		matches := nowebReferenceRegexp.FindAllStringSubmatchIndex(line, -1)
+		if matches == nil {
+			continue
+		}
+		expanded, last := "", 0
+		for _, m := range matches {
+			expansion, err := d.expandNowebReference(line[m[2]:m[3]], stack)
+			if err != nil {
+				return "", err
+			}
+			expanded += line[last:m[0]]
+			prefix := expanded[strings.LastIndex(expanded, "\n")+1:] // previous references on the line are expanded already
+			expanded += strings.ReplaceAll(expansion, "\n", "\n"+prefix)
+			last = m[1]
+		}
+		lines[i] = expanded + line[last:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// expandNowebReference returns the expanded content of the src block named name.
+func (d *Document) expandNowebReference(name string, stack []string) (string, error) {
+	for _, n := range stack {
+		if n == name {
+			return "", fmt.Errorf("noweb reference cycle: %s -> %s", strings.Join(stack, " -> "), name)
+		}
+	}
+	node := d.NamedNodes[name]
+	if n, ok := node.(NodeWithMeta); ok {
+		node = n.Node
+	}
+	b, ok := node.(Block)
+	if !ok || b.Name != "SRC" {
+		return "", fmt.Errorf("could not resolve noweb reference <<%s>>", name)
+	}
+	return d.expandNoweb(strings.TrimSuffix(String(b.Children), "\n"), append(stack[:len(stack):len(stack)], name))
+}
+
+// tangleLinkComments wraps content in comments linking back to the src block (:comments link).
+func (d *Document) tangleLinkComments(content, path, lang string, h *Headline, n int) string {
+	prefix, ok := tangleCommentPrefixes[lang]
+	if !ok {
+		prefix = "//"
+	}
+	link, err := filepath.Rel(filepath.Dir(path), filepath.Base(d.Path))
+	if err != nil || filepath.IsAbs(path) {
+		link = d.Path
+	}
+	link, label := "file:"+filepath.ToSlash(link), fmt.Sprintf("No heading:%d", n)
+	if h != nil {
+		title := String(h.Title)
+		link, label = link+"::*"+title, fmt.Sprintf("%s:%d", title, n)
+	}
+	return fmt.Sprintf("%s [[%s][%s]]\n%s%s %s ends here\n", prefix, link, label, content, prefix, label)
+}
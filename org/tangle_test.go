@@ -0,0 +1,86 @@
+package org
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTangle(t *testing.T) {
+	input := `#+PROPERTY: header-args :mkdirp yes
+#+PROPERTY: header-args:sh :tangle yes :shebang "#!/bin/sh"
+* Main
+:PROPERTIES:
+:header-args:go: :tangle cmd/main.go :comments link :noweb yes
+:END:
+#+BEGIN_SRC go
+package main
+
+func main() {
+	<<greet>>
+}
+#+END_SRC
+
+#+NAME: greet
+#+BEGIN_SRC go :tangle no
+name := "world"
+println("hello", name)
+#+END_SRC
+
+* COMMENT Ignored
+#+BEGIN_SRC go
+ignored
+#+END_SRC
+
+* Scripts
+#+BEGIN_SRC sh
+echo one
+#+END_SRC
+
+#+BEGIN_SRC sh -n :padline no
+echo two (ref:two)
+#+END_SRC
+
+#+BEGIN_SRC python
+print("not tangled")
+#+END_SRC
+`
+	files, err := New().Silent().Parse(strings.NewReader(input), "docs/tangle.org").Tangle()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	expected := []TangledFile{
+		{"cmd/main.go", `// [[file:../tangle.org::*Main][Main:1]]
+package main
+
+func main() {
+	name := "world"
+	println("hello", name)
+}
+// Main:1 ends here
+`, 0644, true},
+		{"tangle.sh", "#!/bin/sh\necho one\necho two\n", 0755, true},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("got\n%#v\nexpected\n%#v", files, expected)
+	}
+}
+
+func TestTangleUnresolvedNowebReference(t *testing.T) {
+	input := "#+BEGIN_SRC go :tangle main.go :noweb yes\n<<missing>>\n#+END_SRC\n"
+	if _, err := New().Silent().Parse(strings.NewReader(input), "").Tangle(); err == nil {
+		t.Errorf("expected error for unresolved noweb reference")
+	}
+}
+
+func TestTangleNowebReferencesOnOneLine(t *testing.T) {
+	input := "#+BEGIN_SRC sh :tangle main.sh :noweb yes\necho <<a>> <<b>>!\n#+END_SRC\n\n" +
+		"#+NAME: a\n#+BEGIN_SRC sh\none\n#+END_SRC\n\n#+NAME: b\n#+BEGIN_SRC sh\ntwo\nthree\n#+END_SRC\n"
+	files, err := New().Silent().Parse(strings.NewReader(input), "").Tangle()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+	if expected := "echo one two\necho one three!\n"; len(files) != 1 || files[0].Content != expected {
+		t.Errorf("got\n%#v\nexpected content %q", files, expected)
+	}
+}
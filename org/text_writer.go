@@ -0,0 +1,674 @@
+package org
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TextWriter exports an org document into plain text (see ox-ascii) - e.g. for emails or terminal output.
+type TextWriter struct {
+	ExtendingWriter Writer
+	Width           int                          // Width is the column paragraphs are wrapped at. 0 disables wrapping.
+	ASCII           bool                         // ASCII restricts the output to ASCII - i.e. entities are kept and tables are drawn using +, - and |.
+	NodeWriters     map[reflect.Type]NodeWriteFn // NodeWriters write custom nodes (see Extensions) - keyed by the type of the node.
+
+	strings.Builder
+	indent         string
+	document       *Document
+	headline       *Headline
+	inline         bool
+	inList         bool
+	sectionNumbers map[int]string
+	footnoteNumbers
+}
+
+// textBoxCharacters contains the characters used to draw tables: horizontal and vertical lines,
+// the corners (top left, top right, bottom left, bottom right) and the junctions (top, bottom, left, right, center).
+type textBoxCharacters struct {
+	horizontal, vertical                                               string
+	topLeft, topRight, bottomLeft, bottomRight                         string
+	topJunction, bottomJunction, leftJunction, rightJunction, junction string
+}
+
+var asciiBox = textBoxCharacters{"-", "|", "+", "+", "+", "+", "+", "+", "+", "+", "+"}
+var unicodeBox = textBoxCharacters{"─", "│", "┌", "┐", "└", "┘", "┬", "┴", "├", "┤", "┼"}
+
+// textUnderlines are the characters used to underline headlines by level (see ox-ascii).
+var textUnderlines = map[bool][]string{
+	true:  {"=", "~", "-"},
+	false: {"═", "─", "╌", "┄", "┈"},
+}
+
+var textEmphasisBorders = map[string][]string{
+	"_":   {"_", "_"},
+	"*":   {"*", "*"},
+	"/":   {"/", "/"},
+	"+":   {"+", "+"},
+	"~":   {"`", "`"},
+	"=":   {"`", "`"},
+	"_{}": {"_", ""},
+	"^{}": {"^", ""},
+}
+
+func NewTextWriter() *TextWriter {
+	return &TextWriter{
+		Width: 72,
+	}
+}
+
+func (w *TextWriter) WriterWithExtensions() Writer {
+	if w.ExtendingWriter != nil {
+		return w.ExtendingWriter
+	}
+	return w
+}
+
+func (w *TextWriter) WriteCustomNode(n Node) {
+	writeCustomNode(w.WriterWithExtensions(), w.NodeWriters, n)
+}
+
+func (w *TextWriter) writtenDocument() *Document { return w.document }
+func (w *TextWriter) builder() *strings.Builder  { return &w.Builder }
+
+func (w *TextWriter) Before(d *Document) {
+	w.document, w.footnoteNumbers = d, footnoteNumbers{}
+	w.sectionNumbers = d.sectionNumbers()
+	if title := d.Get("TITLE"); title != "" && d.GetOption("title") != "nil" {
+		titleDocument := d.parseSubDocument(title)
+		if p, ok := firstParagraph(titleDocument.Nodes); ok && titleDocument.Error == nil {
+			title = w.writeInline(p.Children...)
+		}
+		title = strings.TrimSpace(title)
+		w.WriteString(w.centered(title) + "\n" + w.centered(strings.Repeat(w.underline(0), utf8.RuneCountInString(title))) + "\n")
+	}
+	if d.GetOption("toc") != "nil" {
+		maxLvl, _ := strconv.Atoi(d.GetOption("toc"))
+		w.writeOutline(d.Outline.Section, maxLvl)
+	}
+}
+
+func (w *TextWriter) After(d *Document) {
+	if d.GetOption("f") != "nil" && len(w.footnotes) != 0 {
+		w.writeHeading("Footnotes", 1)
+		w.startBlock()
+		for i := 0; i < len(w.footnotes); i++ { // footnote definitions can reference further footnotes
+			if f := w.footnotes[i]; f == nil {
+				d.Log.Printf("Missing footnote definition for footnote #%d", i+1)
+			} else {
+				w.writeIndented(fmt.Sprintf("[%d] ", i+1), f.Children)
+			}
+		}
+	}
+	out := strings.TrimRightFunc(w.String(), unicode.IsSpace)
+	w.Reset()
+	if out != "" {
+		w.WriteString(out + "\n")
+	}
+}
+
+func (w *TextWriter) WriteNodesAsString(nodes ...Node) string {
+	builder := w.Builder
+	w.Builder = strings.Builder{}
+	defer func() { w.Builder = builder }() // restored on panics as well - see writeNode
+	WriteNodes(w, nodes...)
+	return w.String()
+}
+
+// writeInline returns the inline nodes as a single line of text - (soft) line breaks are replaced by spaces.
+func (w *TextWriter) writeInline(nodes ...Node) string {
+	inline := w.inline
+	w.inline = true
+	out := w.WriteNodesAsString(nodes...)
+	w.inline = inline
+	return out
+}
+
+// escape returns s unchanged - plain text does not need escaping (see plainWriter).
+func (w *TextWriter) escape(s string) string { return s }
+
+// startBlock separates the following block from the previous one by an empty line.
+func (w *TextWriter) startBlock() {
+	if out := w.String(); out != "" && !strings.HasSuffix(out, "\n\n") {
+		if !strings.HasSuffix(out, "\n") {
+			w.WriteString("\n")
+		}
+		w.WriteString("\n")
+	}
+}
+
+// writeLines writes the lines of s prefixed with the current indentation.
+func (w *TextWriter) writeLines(s string) {
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		if line = strings.TrimRightFunc(line, unicode.IsSpace); line != "" {
+			line = w.indent + line
+		}
+		w.WriteString(line + "\n")
+	}
+}
+
+// writeIndented writes nodes indented by the length of prefix - with the prefix (e.g. a list bullet) on the first line.
+func (w *TextWriter) writeIndented(prefix string, nodes []Node) {
+	builder, indent := w.Builder, w.indent
+	w.Builder, w.indent = strings.Builder{}, w.indent+strings.Repeat(" ", utf8.RuneCountInString(prefix))
+	WriteNodes(w, nodes...)
+	content := strings.TrimLeft(w.String(), "\n")
+	content = strings.TrimPrefix(content, w.indent)
+	w.Builder, w.indent = builder, indent
+	w.WriteString(w.indent + prefix + content)
+	if !strings.HasSuffix(content, "\n") {
+		w.WriteString("\n")
+	}
+}
+
+func (w *TextWriter) wrap(s string) []string {
+	width, lines := w.Width-utf8.RuneCountInString(w.indent), []string{}
+	if w.Width > 0 && width < 20 {
+		width = 20
+	}
+	for _, hardLine := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(hardLine) {
+			if line == "" {
+				line = word
+			} else if w.Width > 0 && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+				lines, line = append(lines, line), word
+			} else {
+				line += " " + word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (w *TextWriter) centered(s string) string {
+	if n := (w.Width - utf8.RuneCountInString(s)) / 2; w.Width > 0 && n > 0 {
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
+func (w *TextWriter) underline(lvl int) string {
+	underlines := textUnderlines[w.ASCII]
+	if lvl >= len(underlines) {
+		return underlines[len(underlines)-1]
+	}
+	return underlines[lvl]
+}
+
+func (w *TextWriter) writeHeading(title string, lvl int) {
+	w.startBlock()
+	w.WriteString(w.indent + title + "\n")
+	w.WriteString(w.indent + strings.Repeat(w.underline(lvl-1), utf8.RuneCountInString(title)) + "\n")
+}
+
+func (w *TextWriter) writeOutline(section *Section, maxLvl int) {
+	lines, footnotes, footnoteNames := []string{}, w.footnotes, map[string]int{}
+	for k, v := range w.footnoteNames {
+		footnoteNames[k] = v
+	}
+	defer func() { w.footnotes, w.footnoteNames = footnotes, footnoteNames }() // references in the outline do not count
+	var walk func(*Section)
+	walk = func(s *Section) {
+		for _, child := range s.Children {
+			if h := child.Headline; !h.IsExcluded(w.document) && !w.document.isListHeadline(*h) && (maxLvl == 0 || h.Lvl <= maxLvl) {
+				lines = append(lines, strings.TrimRightFunc(strings.Repeat("  ", h.Lvl-1)+w.headlineTitle(*h, false), unicode.IsSpace))
+				walk(child)
+			}
+		}
+	}
+	walk(section)
+	if len(lines) == 0 {
+		return
+	}
+	w.writeHeading("Table of Contents", 1)
+	w.startBlock()
+	w.WriteString(strings.Join(lines, "\n") + "\n")
+}
+
+func (w *TextWriter) headlineTitle(h Headline, withTags bool) string {
+	title := ""
+	if number, ok := w.sectionNumbers[h.Index]; ok {
+		title += number + " "
+	}
+	if w.document.GetOption("todo") != "nil" && h.Status != "" {
+		title += h.Status + " "
+	}
+	if w.document.GetOption("pri") != "nil" && h.Priority != "" {
+		title += "[#" + h.Priority + "] "
+	}
+	title += w.writeInline(h.Title...)
+	if withTags && w.document.GetOption("tags") != "nil" && len(h.Tags) != 0 {
+		title += "   :" + strings.Join(h.Tags, ":") + ":"
+	}
+	return title
+}
+
+func (w *TextWriter) WriteHeadline(h Headline) {
+	if h.IsExcluded(w.document) {
+		return
+	}
+	parentHeadline := w.headline
+	defer func() { w.headline = parentHeadline }()
+	if w.document.isListHeadline(h) {
+		title := Paragraph{[]Node{Text{w.headlineTitle(h, true), true}}}
+		w.headline = &h
+		w.WriteList(List{Kind: "unordered", Items: []Node{ListItem{Bullet: "-", Children: append([]Node{title}, h.Children...)}}})
+		return
+	}
+	w.writeHeading(w.headlineTitle(h, true), h.Lvl)
+	w.headline = &h
+	WriteNodes(w, h.Children...)
+}
+
+func (w *TextWriter) WriteBlock(b Block) {
+	params := b.HeaderArgs
+	if params == nil {
+		params = b.ParameterMap()
+	}
+	switch b.Name {
+	case "SRC", "EXAMPLE":
+		if params[":exports"] != "results" && params[":exports"] != "none" {
+			w.startBlock()
+			w.writeIndentedLines("  ", strings.TrimRightFunc(w.WriteNodesAsString(b.Children...), unicode.IsSpace))
+		}
+	case "EXPORT":
+		if len(b.Parameters) >= 1 && isTextExportFormat(b.Parameters[0]) {
+			w.startBlock()
+			w.writeLines(w.WriteNodesAsString(b.Children...))
+		}
+	case "VERSE":
+		w.startBlock()
+		w.writeIndentedLines("  ", strings.TrimRightFunc(w.WriteNodesAsString(b.Children...), unicode.IsSpace))
+	case "CENTER":
+		indent, width := w.indent, w.Width
+		w.indent, w.Width = "", w.Width-utf8.RuneCountInString(indent)
+		content := w.WriteNodesAsString(b.Children...)
+		lines := strings.Split(strings.Trim(content, "\n"), "\n")
+		for i, line := range lines {
+			lines[i] = w.centered(strings.TrimSpace(line))
+		}
+		w.indent, w.Width = indent, width
+		w.startBlock()
+		w.writeLines(strings.Join(lines, "\n"))
+	case "QUOTE":
+		indent := w.indent
+		w.indent += "  "
+		WriteNodes(w, b.Children...)
+		if len(b.Parameters) != 0 {
+			w.writeLines("-- " + strings.Join(b.Parameters, " "))
+		}
+		w.indent = indent
+	default:
+		WriteNodes(w, b.Children...)
+	}
+	if b.Result != nil && params[":exports"] != "code" && params[":exports"] != "none" {
+		WriteNodes(w, b.Result)
+	}
+}
+
+// writeIndentedLines writes the verbatim lines of s with an additional indentation - e.g. for src blocks.
+func (w *TextWriter) writeIndentedLines(indent, s string) {
+	original := w.indent
+	w.indent += indent
+	w.writeLines(s)
+	w.indent = original
+}
+
+func isTextExportFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "ascii", "text", "txt", "utf-8", "latin1":
+		return true
+	}
+	return false
+}
+
+func (w *TextWriter) WriteResult(r Result) { WriteNodes(w, r.Node) }
+
+func (w *TextWriter) WriteInlineBlock(b InlineBlock) {
+	switch b.Name {
+	case "src":
+		exports := Block{Parameters: b.Parameters}.ParameterMap()[":exports"]
+		if exports != "results" && exports != "none" {
+			w.WriteString("`" + w.WriteNodesAsString(b.Children...) + "`")
+		}
+		if exports == "results" || exports == "both" {
+			WriteNodes(w, b.Result...)
+		}
+	case "export":
+		if isTextExportFormat(b.Parameters[0]) {
+			WriteNodes(w, b.Children...)
+		}
+	}
+}
+
+func (w *TextWriter) WriteCall(c Call) { writeCall(w, w.document, c) }
+
+func (w *TextWriter) WriteInlineCall(c InlineCall) { writeInlineCall(w, w.document, c) }
+
+func (w *TextWriter) WriteDrawer(d Drawer) { WriteNodes(w, d.Children...) }
+
+func (w *TextWriter) WritePropertyDrawer(PropertyDrawer) {}
+
+func (w *TextWriter) WriteComment(Comment) {}
+
+func (w *TextWriter) WriteKeyword(k Keyword) {
+	switch k.Key {
+	case "ASCII", "TEXT":
+		w.writeLines(k.Value)
+	case "PRINT_BIBLIOGRAPHY":
+		entries := w.document.formatBibliography(textCitationMarkup)
+		if len(entries) == 0 {
+			return
+		}
+		w.startBlock()
+		for _, e := range entries {
+			for i, line := range w.wrap(e[1]) {
+				if i != 0 {
+					line = "  " + line
+				}
+				w.writeLines(line)
+			}
+		}
+	}
+}
+
+var textCitationMarkup = citationMarkup{
+	escape:   func(s string) string { return s },
+	emphasis: func(s string) string { return s },
+	link: func(url, text string) string {
+		if strings.HasPrefix(url, "#") || url == text {
+			return text
+		}
+		return text + " <" + url + ">"
+	},
+}
+
+// plainWriter is implemented by the writers for plain text formats (TextWriter and ManWriter)
+// to share the writing of nodes that only differ in escaping.
+type plainWriter interface {
+	Writer
+	documentWriter
+	WriteString(string) (int, error)
+	writeInline(...Node) string
+	escape(string) string
+}
+
+// plainCaption returns the caption of n - prefixed with the label of its number formatted using labelFormat (e.g. "%s %d: ").
+func plainCaption(w plainWriter, n NodeWithMeta, labelFormat string) string {
+	caption := []string{}
+	for _, ns := range n.Meta.Caption {
+		caption = append(caption, w.writeInline(ns...))
+	}
+	label := ""
+	if c := n.captioned(); c != nil {
+		label = fmt.Sprintf(labelFormat, w.writtenDocument().captionLabel(c.Kind), c.Number)
+	}
+	return label + strings.Join(caption, " ")
+}
+
+// writePlainLink writes links to captioned elements and headlines as their description (or number / title)
+// and all other links as the description followed by the <url>.
+func writePlainLink(w plainWriter, l RegularLink) {
+	d := w.writtenDocument()
+	if c := d.namedCaptioned(l.URL); c != nil && l.Protocol == "" {
+		if l.Description != nil {
+			WriteNodes(w, l.Description...)
+		} else {
+			w.WriteString(strconv.Itoa(c.Number))
+		}
+		return
+	}
+	if h := d.linkedHeadline(l); h != nil {
+		if l.Description != nil {
+			WriteNodes(w, l.Description...)
+		} else {
+			WriteNodes(w, h.Title...)
+		}
+		return
+	}
+	url := l.URL
+	if prefix := d.Links[l.Protocol]; prefix != "" {
+		url = prefix + strings.TrimPrefix(url, l.Protocol+":")
+	}
+	url = w.escape(url)
+	if l.AutoLink {
+		w.WriteString(url)
+	} else if l.Description == nil {
+		w.WriteString("<" + url + ">")
+	} else {
+		w.WriteString(w.writeInline(l.Description...) + " <" + url + ">")
+	}
+}
+
+func (w *TextWriter) WriteInclude(i Include) { writeInclude(w, w.document, i) }
+
+func (w *TextWriter) WriteNodeWithMeta(n NodeWithMeta) {
+	if len(n.Meta.Caption) != 0 {
+		w.startBlock()
+		for _, line := range w.wrap(plainCaption(w, n, "%s %d: ")) {
+			w.writeLines(line)
+		}
+	}
+	WriteNodes(w, n.Node)
+}
+
+func (w *TextWriter) WriteNodeWithName(n NodeWithName) { WriteNodes(w, n.Node) }
+
+func (w *TextWriter) WriteFootnoteDefinition(FootnoteDefinition) {}
+
+func (w *TextWriter) WriteParagraph(p Paragraph) {
+	content := w.writeInline(p.Children...)
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	w.startBlock()
+	for _, line := range w.wrap(content) {
+		w.writeLines(line)
+	}
+}
+
+func (w *TextWriter) WriteExample(e Example) {
+	lines := []string{}
+	for _, n := range e.Children {
+		lines = append(lines, w.WriteNodesAsString(n))
+	}
+	w.startBlock()
+	w.writeIndentedLines("  ", strings.Join(lines, "\n"))
+}
+
+func (w *TextWriter) WriteList(l List) {
+	if !w.inList {
+		w.startBlock()
+	}
+	inList := w.inList
+	w.inList = true
+	WriteNodes(w, l.Items...)
+	w.inList = inList
+}
+
+func (w *TextWriter) WriteListItem(li ListItem) {
+	w.writeIndented(w.listItemPrefix(li.Bullet, li.Status), li.Children)
+}
+
+func (w *TextWriter) WriteDescriptiveListItem(di DescriptiveListItem) {
+	details := di.Details
+	term := []Node{Text{w.writeInline(di.Term...) + ":", true}}
+	if p, ok := firstParagraph(details); ok {
+		p.Children = append(append(term, Text{" ", true}), p.Children...)
+		details = append([]Node{p}, details[1:]...)
+	} else {
+		details = append([]Node{Paragraph{term}}, details...)
+	}
+	w.writeIndented(w.listItemPrefix(di.Bullet, di.Status), details)
+}
+
+func firstParagraph(nodes []Node) (Paragraph, bool) {
+	if len(nodes) == 0 {
+		return Paragraph{}, false
+	}
+	p, ok := nodes[0].(Paragraph)
+	return p, ok
+}
+
+func (w *TextWriter) listItemPrefix(bullet, status string) string {
+	if bullet == "+" || bullet == "*" {
+		bullet = "-"
+	}
+	if status != "" {
+		bullet += " [" + status + "]"
+	}
+	return bullet + " "
+}
+
+func (w *TextWriter) WriteTable(t Table) {
+	box := unicodeBox
+	if w.ASCII {
+		box = asciiBox
+	}
+	// ColumnInfo.Len is the width of the raw Org mode cells (markup, link urls, width cookies, ...) - the box has to
+	// fit the written cells instead, so their widths are computed here. The alignment is taken from the ColumnInfos.
+	widths := make([]int, len(t.ColumnInfos))
+	rows := [][]string{}
+	for _, row := range t.Rows {
+		if len(row.Columns) == 0 {
+			rows = append(rows, nil)
+			continue
+		} else if row.IsSpecial {
+			continue
+		}
+		columns := make([]string, len(row.Columns))
+		for i, column := range row.Columns {
+			columns[i] = w.writeInline(column.Children...)
+			if n := utf8.RuneCountInString(columns[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+		rows = append(rows, columns)
+	}
+	for len(rows) != 0 && rows[0] == nil {
+		rows = rows[1:]
+	}
+	for len(rows) != 0 && rows[len(rows)-1] == nil {
+		rows = rows[:len(rows)-1]
+	}
+	rule := func(left, junction, right string) string {
+		parts := make([]string, len(widths))
+		for i, width := range widths {
+			parts[i] = strings.Repeat(box.horizontal, width+2)
+		}
+		return left + strings.Join(parts, junction) + right
+	}
+	lines := []string{rule(box.topLeft, box.topJunction, box.topRight)}
+	for _, columns := range rows {
+		if columns == nil {
+			lines = append(lines, rule(box.leftJunction, box.junction, box.rightJunction))
+			continue
+		}
+		line := box.vertical
+		for i, content := range columns {
+			n := widths[i] - utf8.RuneCountInString(content)
+			switch t.ColumnInfos[i].Align {
+			case "center":
+				content = strings.Repeat(" ", n/2) + content + strings.Repeat(" ", n-n/2)
+			case "right":
+				content = strings.Repeat(" ", n) + content
+			default:
+				content = content + strings.Repeat(" ", n)
+			}
+			line += " " + content + " " + box.vertical
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, rule(box.bottomLeft, box.bottomJunction, box.bottomRight))
+	w.startBlock()
+	w.writeLines(strings.Join(lines, "\n"))
+}
+
+func (w *TextWriter) WriteHorizontalRule(HorizontalRule) {
+	width, rule := w.Width-utf8.RuneCountInString(w.indent), "─"
+	if width <= 0 {
+		width = 5
+	}
+	if w.ASCII {
+		rule = "-"
+	}
+	w.startBlock()
+	w.writeLines(strings.Repeat(rule, width))
+}
+
+func (w *TextWriter) WriteText(t Text) {
+	if w.ASCII || t.IsRaw || w.document.GetOption("e") == "nil" {
+		w.WriteString(t.Content)
+	} else {
+		w.WriteString(htmlEntityReplacer.Replace(t.Content))
+	}
+}
+
+func (w *TextWriter) WriteEmphasis(e Emphasis) {
+	borders, ok := textEmphasisBorders[e.Kind]
+	if !ok {
+		panic(fmt.Sprintf("bad emphasis %#v", e))
+	}
+	w.WriteString(borders[0])
+	WriteNodes(w, e.Content...)
+	w.WriteString(borders[1])
+}
+
+func (w *TextWriter) WriteLatexFragment(l LatexFragment) {
+	w.WriteString(l.OpeningPair)
+	WriteNodes(w, l.Content...)
+	w.WriteString(l.ClosingPair)
+}
+
+func (w *TextWriter) WriteStatisticToken(s StatisticToken) {
+	w.WriteString(fmt.Sprintf("[%s]", s.Content))
+}
+
+func (w *TextWriter) WriteLineBreak(l LineBreak) {
+	if w.inline {
+		w.WriteString(" ")
+	} else {
+		w.WriteString(strings.Repeat("\n", l.Count))
+	}
+}
+
+func (w *TextWriter) WriteExplicitLineBreak(ExplicitLineBreak) {
+	w.WriteString("\n")
+}
+
+func (w *TextWriter) WriteTimestamp(t Timestamp) {
+	if w.document.GetOption("<") == "nil" {
+		return
+	}
+	w.WriteString("<")
+	if t.IsDate {
+		w.WriteString(t.Time.Format(datestampFormat))
+	} else {
+		w.WriteString(t.Time.Format(timestampFormat))
+	}
+	if t.Interval != "" {
+		w.WriteString(" " + t.Interval)
+	}
+	w.WriteString(">")
+}
+
+func (w *TextWriter) WriteFootnoteLink(l FootnoteLink) {
+	if w.document.GetOption("f") != "nil" {
+		w.WriteString(fmt.Sprintf("[%d]", w.number(w.document, l)))
+	}
+}
+
+func (w *TextWriter) WriteCitation(c Citation) {
+	w.WriteString(w.document.formatCitation(c, textCitationMarkup))
+}
+
+func (w *TextWriter) WriteRegularLink(l RegularLink) { writePlainLink(w, l) }
+
+func (w *TextWriter) WriteMacro(m Macro) {
+	w.document.writeMacro(w, m, w.headline)
+}
@@ -0,0 +1,76 @@
+package org
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextWriter(t *testing.T) {
+	for _, path := range orgTestFiles() {
+		expected := fileString(path[:len(path)-len(".org")] + ".txt")
+		reader, writer := strings.NewReader(fileString(path)), NewTextWriter()
+		actual, err := New().Silent().Parse(reader, path).Write(writer)
+		if err != nil {
+			t.Errorf("%s\n got error: %s", path, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%s:\n%s'", path, diff(actual, expected))
+		} else {
+			t.Logf("%s: passed!", path)
+		}
+	}
+}
+
+func TestTextWriterOptions(t *testing.T) {
+	writer := NewTextWriter()
+	writer.Width = 40
+	input := "A paragraph with a [[https://example.com][link]] that is wrapped at the configured width.\n"
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if expected := "A paragraph with a link\n<https://example.com> that is wrapped at\nthe configured width.\n"; actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+
+	writer = NewTextWriter()
+	writer.ASCII = true
+	actual, err = New().Silent().Parse(strings.NewReader("| a | b |\n|---+---|\n| 1 | 2 |\n\n\\alpha\n"), "").Write(writer)
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if expected := "+---+---+\n| a | b |\n+---+---+\n| 1 | 2 |\n+---+---+\n\n\\alpha\n"; actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
+
+func TestTextWriterReuse(t *testing.T) {
+	write := func(w Writer, input string) string {
+		actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(w)
+		if err != nil {
+			t.Fatalf("got error: %s", err)
+		}
+		return actual
+	}
+	first, second := "A footnote[fn:a].\n\n[fn:a] The first footnote.\n", "Another footnote[fn:b].\n\n[fn:b] The second footnote.\n"
+	textWriter, manWriter := NewTextWriter(), NewManWriter()
+	write(textWriter, first)
+	write(manWriter, first)
+	textWriter.Reset()
+	manWriter.Reset()
+	if actual, expected := write(textWriter, second), write(NewTextWriter(), second); actual != expected {
+		t.Errorf("expected the footnotes of the first document to not be carried over:\n%s", diff(actual, expected))
+	}
+	if actual, expected := write(manWriter, second), write(NewManWriter(), second); actual != expected {
+		t.Errorf("expected the footnotes of the first document to not be carried over:\n%s", diff(actual, expected))
+	}
+}
+
+func TestTextWriterHeadlineOptions(t *testing.T) {
+	input := "#+OPTIONS: num:t H:1 toc:nil\n\n* Headline\n** List headline\ncontent\n"
+	actual, err := New().Silent().Parse(strings.NewReader(input), "").Write(NewTextWriter())
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	} else if expected := "1 Headline\n══════════\n\n- List headline\n\n  content\n"; actual != expected {
+		t.Errorf("\n%s", diff(actual, expected))
+	}
+}
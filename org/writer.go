@@ -1,6 +1,9 @@
 package org
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Writer is the interface that is used to export a parsed document into a new format. See Document.Write().
 type Writer interface {
@@ -42,72 +45,195 @@ type Writer interface {
 	WriteFootnoteDefinition(FootnoteDefinition)
 }
 
+// CustomNodeWriter is implemented by writers that can write nodes returned from custom parsers (see Extensions).
+type CustomNodeWriter interface {
+	WriteCustomNode(Node)
+}
+
+// CitationWriter is implemented by writers that can format citations (see #+BIBLIOGRAPHY and #+CITE_EXPORT).
+// Other writers write citations as plain text.
+type CitationWriter interface {
+	WriteCitation(Citation)
+}
+
+// CallWriter is implemented by writers that can write #+CALL lines and inline call_ blocks (e.g. evaluate them).
+// Other writers write the cached results of calls - or the calls as plain text if there are none.
+type CallWriter interface {
+	WriteCall(Call)
+	WriteInlineCall(InlineCall)
+}
+
+// documentWriter is implemented by the builtin writers (and writers embedding them).
+// It gives WriteNodes access to the document that is currently being written - e.g. to check its context for cancellation -
+// and to the output of the writer.
+type documentWriter interface {
+	writtenDocument() *Document
+	builder() *strings.Builder
+}
+
+// WriteDiagnostics returns the problems with single nodes that were written as plain text instead during the
+// last write using w. It is only supported for the builtin writers (and writers embedding them).
+func WriteDiagnostics(w Writer) []Diagnostic {
+	if d := writtenDocument(w); d != nil && d.write != nil {
+		return d.write.diagnostics
+	}
+	return nil
+}
+
+func writtenDocument(w Writer) *Document {
+	if dw, ok := w.(documentWriter); ok {
+		return dw.writtenDocument()
+	}
+	return nil
+}
+
 func WriteNodes(w Writer, nodes ...Node) {
 	w = w.WriterWithExtensions()
-	for _, n := range nodes {
-		switch n := n.(type) {
-		case Keyword:
-			w.WriteKeyword(n)
-		case Include:
-			w.WriteInclude(n)
-		case Comment:
-			w.WriteComment(n)
-		case NodeWithMeta:
-			w.WriteNodeWithMeta(n)
-		case NodeWithName:
-			w.WriteNodeWithName(n)
-		case Headline:
-			w.WriteHeadline(n)
-		case Block:
-			w.WriteBlock(n)
-		case Result:
-			w.WriteResult(n)
-		case InlineBlock:
-			w.WriteInlineBlock(n)
-		case Example:
-			w.WriteExample(n)
-		case Drawer:
-			w.WriteDrawer(n)
-		case PropertyDrawer:
-			w.WritePropertyDrawer(n)
-		case List:
-			w.WriteList(n)
-		case ListItem:
-			w.WriteListItem(n)
-		case DescriptiveListItem:
-			w.WriteDescriptiveListItem(n)
-		case Table:
-			w.WriteTable(n)
-		case HorizontalRule:
-			w.WriteHorizontalRule(n)
-		case Paragraph:
-			w.WriteParagraph(n)
-		case Text:
-			w.WriteText(n)
-		case Emphasis:
-			w.WriteEmphasis(n)
-		case LatexFragment:
-			w.WriteLatexFragment(n)
-		case StatisticToken:
-			w.WriteStatisticToken(n)
-		case ExplicitLineBreak:
-			w.WriteExplicitLineBreak(n)
-		case LineBreak:
-			w.WriteLineBreak(n)
-		case RegularLink:
-			w.WriteRegularLink(n)
-		case Macro:
-			w.WriteMacro(n)
-		case Timestamp:
-			w.WriteTimestamp(n)
-		case FootnoteLink:
-			w.WriteFootnoteLink(n)
-		case FootnoteDefinition:
-			w.WriteFootnoteDefinition(n)
-		default:
-			if n != nil {
-				panic(fmt.Sprintf("bad node %T %#v", n, n))
+	d := writtenDocument(w)
+	for i, n := range nodes {
+		line := 0
+		if d != nil {
+			d.checkContext()
+			line = d.nodeLines[&nodes[i]]
+		}
+		writeNode(w, n, line)
+	}
+}
+
+// writeNode writes a single node - line is its line in the input (0 if unknown). If writing the node fails,
+// its partial output is discarded and it is written as plain text instead.
+func writeNode(w Writer, n Node, line int) {
+	var d *Document
+	var out *strings.Builder
+	start := 0
+	if dw, ok := w.(documentWriter); ok && dw.writtenDocument() != nil {
+		// out is truncated rather than swapped with an empty builder as writers inspect the preceding output (e.g. startBlock)
+		d, out = dw.writtenDocument(), dw.builder()
+		start = out.Len()
+		if line != 0 {
+			write := d.writing()
+			parentLine := write.line
+			write.line = line
+			defer func() { write.line = parentLine }()
+		}
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if ow, ok := w.(*OrgWriter); ok && ow.strict {
+				panic(recovered)
+			}
+			err := recoverable(recovered)
+			if d != nil {
+				// writers restore their builder if the panic happened inside of WriteNodesAsString
+				if s := out.String(); len(s) > start {
+					*out = strings.Builder{}
+					out.WriteString(s[:start])
+				}
+				d.addDiagnostic(d.writing().line, fmt.Sprintf("could not write %T: %v", n, err))
 			}
+			w.WriteText(Text{nodeString(n), true})
+		}
+	}()
+	switch n := n.(type) {
+	case Keyword:
+		w.WriteKeyword(n)
+	case Include:
+		w.WriteInclude(n)
+	case Comment:
+		w.WriteComment(n)
+	case NodeWithMeta:
+		w.WriteNodeWithMeta(n)
+	case NodeWithName:
+		w.WriteNodeWithName(n)
+	case Headline:
+		w.WriteHeadline(n)
+	case Block:
+		w.WriteBlock(n)
+	case Result:
+		w.WriteResult(n)
+	case InlineBlock:
+		w.WriteInlineBlock(n)
+	case Call:
+		if cw, ok := w.(CallWriter); ok {
+			cw.WriteCall(n)
+		} else if n.Result != nil {
+			WriteNodes(w, n.Result)
+		} else {
+			w.WriteText(Text{nodeString(n), true})
+		}
+	case InlineCall:
+		if cw, ok := w.(CallWriter); ok {
+			cw.WriteInlineCall(n)
+		} else if n.Result != nil {
+			WriteNodes(w, n.Result...)
+		} else {
+			w.WriteText(Text{nodeString(n), true})
+		}
+	case Example:
+		w.WriteExample(n)
+	case Drawer:
+		w.WriteDrawer(n)
+	case PropertyDrawer:
+		w.WritePropertyDrawer(n)
+	case List:
+		w.WriteList(n)
+	case ListItem:
+		w.WriteListItem(n)
+	case DescriptiveListItem:
+		w.WriteDescriptiveListItem(n)
+	case Table:
+		w.WriteTable(n)
+	case HorizontalRule:
+		w.WriteHorizontalRule(n)
+	case Paragraph:
+		w.WriteParagraph(n)
+	case Text:
+		w.WriteText(n)
+	case Emphasis:
+		w.WriteEmphasis(n)
+	case LatexFragment:
+		w.WriteLatexFragment(n)
+	case StatisticToken:
+		w.WriteStatisticToken(n)
+	case ExplicitLineBreak:
+		w.WriteExplicitLineBreak(n)
+	case LineBreak:
+		w.WriteLineBreak(n)
+	case RegularLink:
+		w.WriteRegularLink(n)
+	case Macro:
+		w.WriteMacro(n)
+	case Timestamp:
+		w.WriteTimestamp(n)
+	case FootnoteLink:
+		w.WriteFootnoteLink(n)
+	case Citation:
+		if cw, ok := w.(CitationWriter); ok {
+			cw.WriteCitation(n)
+		} else {
+			w.WriteText(Text{nodeString(n), true})
+		}
+	case FootnoteDefinition:
+		w.WriteFootnoteDefinition(n)
+	default:
+		if ow, ok := w.(*OrgWriter); ok && ow.strict && n != nil {
+			ow.WriteString(n.String())
+		} else if cw, ok := w.(CustomNodeWriter); ok && n != nil {
+			cw.WriteCustomNode(n)
+		} else if n != nil {
+			panic(fmt.Sprintf("bad node %T %#v", n, n))
 		}
 	}
 }
+
+// nodeString returns the Org mode string for n - or an empty string if n cannot be written as Org mode either.
+func nodeString(n Node) (s string) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s = ""
+		}
+	}()
+	w := NewOrgWriter()
+	w.strict = true
+	return w.WriteNodesAsString(n)
+}